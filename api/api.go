@@ -2,9 +2,6 @@ package api
 
 import (
 	"log"
-	"github.com/google/easypki/pkg/easypki"
-	"crypto/x509"
-	"github.com/google/easypki/pkg/certificate"
 	"os"
 	"encoding/pem"
 
@@ -16,9 +13,25 @@ import (
 	"fmt"
 	"crypto/x509/pkix"
 	"net/url"
+	"crypto/x509"
+	"io/ioutil"
+	"crypto"
+	"errors"
+	"io"
+	"strings"
+	"strconv"
+	"encoding/base64"
+
+	"golang.org/x/crypto/ocsp"
 )
 
 type API struct {
+	// Reloader, when set, provides the configuration reload status.
+	Reloader *config.Reloader
+	// Anonymous lets unauthenticated users change the PKI, for deployments
+	// without identity providers.
+	Anonymous bool
+
 	cfg *config.Config
 	r   *mux.Router
 }
@@ -27,13 +40,27 @@ type Routes string
 
 const (
 	ListHandler Routes = "CertificateList"
+	PlanHandler Routes = "Plan"
+	Schema      Routes = "Schema"
+	Status      Routes = "Status"
 
 	CAInfo   Routes = "CAInfo"
 	CertInfo Routes = "CertInfo"
 
 	CaCertFile    Routes = "CACertFile"
+	CaCertDERFile Routes = "CACertDERFile"
 	CertFile      Routes = "CertFile"
 	CertChainFile Routes = "CertChainFile"
+	CaCSRFile     Routes = "CACSRFile"
+	CaImport      Routes = "CAImport"
+	CaSign        Routes = "CASign"
+	CaCRL         Routes = "CACRL"
+	CaCRLPEM      Routes = "CACRLPEM"
+	CaOCSP        Routes = "CAOCSP"
+	CaOCSPGet     Routes = "CAOCSPGet"
+	CertRevoke    Routes = "CertRevoke"
+
+	CreateCert Routes = "CreateCertificate"
 )
 
 func (a *API) Setup(cfg *config.Config, r *mux.Router) *mux.Router {
@@ -43,6 +70,34 @@ func (a *API) Setup(cfg *config.Config, r *mux.Router) *mux.Router {
 	r.HandleFunc("/", a.CertificateListHandler).
 		Methods("GET").
 		Name(string(ListHandler))
+	r.HandleFunc("/plan", a.PlanHandler).
+		Methods("GET").
+		Name(string(PlanHandler))
+	r.HandleFunc("/schema", a.SchemaHandler).
+		Methods("GET").
+		Name(string(Schema))
+	r.HandleFunc("/status", a.StatusHandler).
+		Methods("GET").
+		Name(string(Status))
+	r.HandleFunc("/certificates", a.requireUser(a.CreateCertificateHandler)).
+		Methods("POST").
+		Name(string(CreateCert))
+	// The CRL routes have to come before certificate information, which
+	// would take crl for a certificate name.
+	r.HandleFunc("/{issuer}/crl", a.CRLHandler).
+		Methods("GET").
+		Name(string(CaCRL))
+	r.HandleFunc("/{issuer}/crl.pem", a.CRLHandler).
+		Methods("GET").
+		Name(string(CaCRLPEM))
+	r.HandleFunc("/{issuer}/ocsp", a.OCSPHandler).
+		Methods("POST").
+		Name(string(CaOCSP))
+	// GET requests carry the base64 request in the path, which may
+	// contain slashes.
+	r.HandleFunc("/{issuer}/ocsp/{request:.+}", a.OCSPHandler).
+		Methods("GET").
+		Name(string(CaOCSPGet))
 	r.HandleFunc("/{issuer}", a.CertificateHandler).
 		Methods("GET").
 		Name(string(CAInfo))
@@ -52,13 +107,30 @@ func (a *API) Setup(cfg *config.Config, r *mux.Router) *mux.Router {
 	r.HandleFunc("/{issuer}/file/cert", a.CertificateBundleHandler).
 		Methods("GET").
 		Name(string(CaCertFile))
-	r.HandleFunc("/{issuer}/{name}/file/cert", a.CertificateBundleHandler).
+	r.HandleFunc("/{issuer}/file/cert.der", a.CACertificateDERHandler).
 		Methods("GET").
-		Name(string(CertFile))
+		Name(string(CaCertDERFile))
+	// The route with the chain query has to come first, the other one
+	// matches regardless of the query.
 	r.HandleFunc("/{issuer}/{name}/file/cert", a.CertificateBundleHandler).
 		Methods("GET").
 		Queries("chain", "{chain}").
 		Name(string(CertChainFile))
+	r.HandleFunc("/{issuer}/{name}/file/cert", a.CertificateBundleHandler).
+		Methods("GET").
+		Name(string(CertFile))
+	r.HandleFunc("/{issuer}/file/csr", a.CertificateRequestHandler).
+		Methods("GET").
+		Name(string(CaCSRFile))
+	r.HandleFunc("/{issuer}/import", a.requireUser(a.ImportHandler)).
+		Methods("POST").
+		Name(string(CaImport))
+	r.HandleFunc("/{issuer}/sign", a.requireUser(a.SignHandler)).
+		Methods("POST").
+		Name(string(CaSign))
+	r.HandleFunc("/{issuer}/{name}/revoke", a.requireUser(a.RevokeHandler)).
+		Methods("POST").
+		Name(string(CertRevoke))
 
 	return r
 }
@@ -73,6 +145,8 @@ type LightWeightCertificate struct {
 	NotAfter   time.Time `json:"notAfter"`
 	NotBefore  time.Time `json:"notBefore"`
 	Issuer     string    `json:"issuer"`
+	// Source is where the certificate is defined, when known.
+	Source     string    `json:"source,omitempty"`
 
 	Href string `json:"href"`
 
@@ -84,9 +158,22 @@ type Certificate struct {
 	Subject        pkix.Name              `json:"subject"`
 	DNSNames       []string               `json:"dnsNames"`
 	EmailAddresses []string               `json:"emailAddresses"`
+	IPAddresses    []string               `json:"ipAddresses"`
+	URIs           []string               `json:"uris"`
 	NotBefore      time.Time              `json:"notBefore"`
 	NotAfter       time.Time              `json:"notAfter"`
 	Issuer         LightWeightCertificate `json:"issuer"`
+	Source         string                 `json:"source,omitempty"`
+
+	CRLDistributionPoints  []string `json:"crlDistributionPoints,omitempty"`
+	OCSPServers            []string `json:"ocspServers,omitempty"`
+	IssuingCertificateURLs []string `json:"issuingCertificateURLs,omitempty"`
+
+	Extensions []config.DecodedExtension `json:"extensions"`
+
+	KeyAlgorithm       string `json:"keyAlgorithm"`
+	KeySize            int    `json:"keySize,omitempty"`
+	SignatureAlgorithm string `json:"signatureAlgorithm"`
 
 	Href string `json:"href"`
 }
@@ -115,16 +202,65 @@ func (a *API) CertificateListHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (a *API) get(issuer string, name string) (*certificate.Bundle, *url.URL, error) {
+// PlanHandler returns what reconciling the configuration would change,
+// without changing anything.
+func (a *API) PlanHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	plan, err := a.cfg.Plan()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		panic(err)
+	}
+}
+
+// SchemaHandler returns the JSON Schema of configuration files.
+func (a *API) SchemaHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json; charset=UTF-8")
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(config.Schema()); err != nil {
+		panic(err)
+	}
+}
+
+type StatusResp struct {
+	Reload *config.ReloadStatus `json:"reload,omitempty"`
+}
+
+func (a *API) StatusHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	status := StatusResp{}
+	if a.Reloader != nil {
+		reload := a.Reloader.Status()
+		status.Reload = &reload
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		panic(err)
+	}
+}
+
+func (a *API) get(issuer string, name string) (*config.Bundle, *url.URL, error) {
 	var err error
-	var bundle *certificate.Bundle
+	var bundle *config.Bundle
 	var href *url.URL
 
 	if name == "" {
-		bundle, err = a.cfg.EasyPKI.GetCA(issuer)
+		bundle, err = a.cfg.GetCA(issuer)
 		href, _ = a.r.Get(string(CAInfo)).URL("issuer", issuer)
 	} else {
-		bundle, err = a.cfg.EasyPKI.GetBundle(issuer, name)
+		bundle, err = a.cfg.GetBundle(issuer, name)
 		href, _ = a.r.Get(string(CertInfo)).URL("issuer", issuer, "name", name)
 	}
 
@@ -156,7 +292,58 @@ func (a *API) CertificateHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	issuer, issuerHref, err := a.get(bundle.Cert.Issuer.CommonName, "")
+	defName := name
+	if defName == "" {
+		defName = issuerName
+	}
+	cert, err := a.certificate(bundle, name, defName, href, req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(cert); err != nil {
+		panic(err)
+	}
+}
+
+// certificate describes bundle, issued for the definition defName, as the
+// resource found at href. The issuer is the CA the definition names as its
+// signer; only certificates without a definition, externally signed CAs and
+// CAs imported with a signer outside of the PKI have theirs described from
+// the certificate.
+func (a *API) certificate(bundle *config.Bundle, name, defName string, href *url.URL, req *http.Request) (Certificate, error) {
+	conf, err := a.cfg.Store.Get(defName)
+	if err != nil {
+		return Certificate{}, err
+	}
+	var signer *config.Cert
+	if conf != nil && !conf.External {
+		signerName := conf.Signer
+		if signerName == "" {
+			signerName = conf.Name
+		}
+		if signer, err = a.cfg.Store.Get(signerName); err != nil {
+			return Certificate{}, err
+		}
+	}
+
+	var issuer *config.Bundle
+	var issuerHref *url.URL
+	external := signer == nil
+	if external {
+		// Externally signed CAs have their issuer outside of the PKI.
+		issuer, issuerHref = &config.Bundle{Name: bundle.Cert.Issuer.CommonName, Cert: &x509.Certificate{Subject: bundle.Cert.Issuer}}, &url.URL{}
+		if chain, _ := a.cfg.ExternalChain(bundle.Name); len(chain) > 0 {
+			issuer.Cert = chain[0]
+		}
+	} else if issuer, issuerHref, err = a.get(signer.Name, ""); err != nil {
+		return Certificate{}, fmt.Errorf("failed getting signing CA %v: %v", signer.Name, err)
+	}
 
 	cert := Certificate{
 		Name:           name,
@@ -177,8 +364,140 @@ func (a *API) CertificateHandler(w http.ResponseWriter, req *http.Request) {
 
 		Href: decorateUrl(href, req).String(),
 	}
+	for _, ip := range bundle.Cert.IPAddresses {
+		cert.IPAddresses = append(cert.IPAddresses, ip.String())
+	}
+	for _, uri := range bundle.Cert.URIs {
+		cert.URIs = append(cert.URIs, uri.String())
+	}
+	if conf != nil {
+		cert.Source = conf.Source.String()
+	}
+	cert.KeyAlgorithm, cert.KeySize = config.PublicKeyParams(bundle.Cert.PublicKey)
+	cert.SignatureAlgorithm = bundle.Cert.SignatureAlgorithm.String()
+	cert.CRLDistributionPoints = bundle.Cert.CRLDistributionPoints
+	cert.OCSPServers = bundle.Cert.OCSPServer
+	cert.IssuingCertificateURLs = bundle.Cert.IssuingCertificateURL
+	cert.Extensions = config.DecodeExtensions(bundle.Cert)
+	if external {
+		cert.Issuer.Href = ""
+	}
 
-	w.WriteHeader(http.StatusOK)
+	return cert, nil
+}
+
+// CreateCertificateHandler adds the JSON certificate definition in the body
+// to the configuration and issues it. It responds with the new certificate
+// and its location.
+func (a *API) CreateCertificateHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	var def config.Cert
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("invalid certificate definition: %s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	a.create(w, req, def)
+}
+
+// SignHandler issues a certificate signed by the issuer for the PEM or DER
+// certificate request in the body. The certificate is named after the name
+// query parameter, or the request's common name, and may be given one of the
+// issuer's signProfiles and an expire duration. It responds like
+// CreateCertificateHandler.
+func (a *API) SignHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	issuer := mux.Vars(req)["issuer"]
+	query := req.URL.Query()
+
+	var def config.Cert
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 1<<20))
+	if err == nil {
+		var csr *x509.CertificateRequest
+		if csr, err = config.ParseCSR(data); err == nil {
+			def, err = config.RequestCert(query.Get("name"), issuer, csr)
+		}
+	}
+	if err == nil {
+		// The CA decides which profiles requests may ask for.
+		var ca *config.Cert
+		if ca, err = a.cfg.Store.Get(issuer); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+				panic(err)
+			}
+			return
+		}
+		if ca == nil {
+			ca = &config.Cert{Name: issuer}
+		}
+		def.Profile, err = config.SignProfile(*ca, query.Get("profile"))
+	}
+	if err == nil {
+		if expire := query.Get("expire"); expire != "" {
+			var d time.Duration
+			if d, err = time.ParseDuration(expire); err != nil {
+				err = fmt.Errorf("invalid expire: %v", err)
+			}
+			def.Expire = config.Duration(d)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	a.create(w, req, def)
+}
+
+// create adds and issues the certificate definition def, responding with the
+// new certificate and its location.
+func (a *API) create(w http.ResponseWriter, req *http.Request, def config.Cert) {
+	if err := a.cfg.Create(def); err != nil {
+		status := http.StatusUnprocessableEntity
+		if _, ok := err.(config.ValidationErrors); ok {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, config.ErrExists) {
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	// Roots are found below themselves.
+	issuer := def.Signer
+	if issuer == "" {
+		issuer = def.Name
+	}
+	bundle, href, err := a.get(issuer, def.Name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	cert, err := a.certificate(bundle, def.Name, def.Name, href, req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w.Header().Set("Location", cert.Href)
+	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(cert); err != nil {
 		panic(err)
 	}
@@ -188,6 +507,9 @@ func (a *API) CertificateBundleHandler(w http.ResponseWriter, req *http.Request)
 
 	vars := mux.Vars(req)
 	name := vars["name"]
+	if name == "" {
+		name = vars["issuer"]
+	}
 	fullChain := vars["chain"] == "full"
 
 	var err error
@@ -210,58 +532,349 @@ func (a *API) CertificateBundleHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/x-pem-file; charset=UTF-8")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.crt", conf.Name))
-
-	var bundle *certificate.Bundle
+	var bundle *config.Bundle
 	if conf.IsCA {
-		bundle, err = a.cfg.EasyPKI.GetCA(conf.Name)
+		bundle, err = a.cfg.GetCA(conf.Name)
 	} else {
-		bundle, err = a.cfg.EasyPKI.GetBundle(conf.Signer, conf.Name)
+		bundle, err = a.cfg.GetBundle(conf.Signer, conf.Name)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusConflict)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%v is not issued: %s", conf.Name, err)}); err != nil {
+			panic(err)
+		}
+		return
 	}
 
-	leaf := bundle
-	chain := []*certificate.Bundle{bundle}
+	chain := []*config.Bundle{bundle}
 	if fullChain {
-		for {
-			if leaf.Cert.Issuer.CommonName == leaf.Cert.Subject.CommonName {
+		// The chain follows the signers of the definitions, which unlike
+		// common names identify the CAs' bundles.
+		for def := conf; ; {
+			// Externally signed CAs continue with the chain they were
+			// imported with.
+			if def.External {
+				external, _ := a.cfg.ExternalChain(def.Name)
+				for _, c := range external {
+					chain = append(chain, &config.Bundle{Name: c.Subject.CommonName, Cert: c})
+				}
+				break
+			}
+			if def.Signer == "" || def.Signer == def.Name {
+				break
+			}
+			// Imported CAs may name a signer outside of the PKI.
+			signer, err := a.cfg.Store.Get(def.Signer)
+			if err != nil || signer == nil {
 				break
 			}
-			ca, err := a.cfg.EasyPKI.GetCA(leaf.Cert.Issuer.CommonName)
+			ca, err := a.cfg.GetCA(def.Signer)
 			if err != nil {
-				log.Fatalf("Failed getting signing CA %v: %v", leaf.Cert.Issuer.CommonName, err)
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.WriteHeader(http.StatusConflict)
+				if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("signing CA %v is not issued: %s", def.Signer, err)}); err != nil {
+					panic(err)
+				}
+				return
 			}
 			chain = append(chain, ca)
-			leaf = ca
+			def = signer
 		}
 	}
+
+	var out []byte
 	for _, c := range chain {
-		if err := pem.Encode(w, &pem.Block{
+		out = append(out, pem.EncodeToMemory(&pem.Block{
 			Bytes: c.Cert.Raw,
 			Type:  "CERTIFICATE",
+		})...)
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.crt", conf.Name))
+	w.Write(out)
+}
+
+// CACertificateDERHandler returns the certificate of a CA DER encoded, the
+// form its issuing certificate URL points to.
+func (a *API) CACertificateDERHandler(w http.ResponseWriter, req *http.Request) {
+	issuer := mux.Vars(req)["issuer"]
+
+	conf, err := a.cfg.Store.Get(issuer)
+	var bundle *config.Bundle
+	if err == nil && conf != nil && conf.IsCA {
+		bundle, err = a.cfg.GetCA(issuer)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if bundle == nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("not found")}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-cert")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.cer", bundle.Name))
+	w.WriteHeader(http.StatusOK)
+	w.Write(bundle.Cert.Raw)
+}
+
+// CertificateRequestHandler returns the pending certificate request of an
+// externally signed CA, PEM encoded, for the external CA to sign.
+func (a *API) CertificateRequestHandler(w http.ResponseWriter, req *http.Request) {
+	issuer := mux.Vars(req)["issuer"]
+
+	csr, err := a.cfg.Request(issuer)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if csr == nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusNotFound)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("not found")}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkcs10; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csr", issuer))
+	w.WriteHeader(http.StatusOK)
+	if err := pem.Encode(w, &pem.Block{
+		Bytes: csr.Raw,
+		Type:  "CERTIFICATE REQUEST",
+	}); err != nil {
+		log.Printf("Failed ecoding %v certificate request: %v", issuer, err)
+	}
+}
+
+// ImportHandler imports the certificate of an externally signed or an
+// imported CA. For an externally signed CA the body holds the PEM
+// certificate an external CA signed for its pending request, followed by the
+// certificates above it, or a single DER certificate. For an imported CA it
+// holds the PEM certificate and private key. The certificates below the CA
+// are issued right away and the resulting plan returned.
+func (a *API) ImportHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	issuer := mux.Vars(req)["issuer"]
+
+	var certs []*x509.Certificate
+	var key crypto.Signer
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 1<<20))
+	if err == nil {
+		certs, key, err = config.ParsePEM(data)
+	}
+	if err == nil {
+		if key != nil {
+			err = a.cfg.ImportCA(issuer, certs[0], key)
+		} else {
+			err = a.cfg.Import(issuer, certs[0], certs[1:])
+		}
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		switch err {
+		case config.ErrNotFound:
+			status = http.StatusNotFound
+		case config.ErrNoRequest:
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	a.reconcile(w, "imported")
+}
+
+// RevokeHandler revokes a certificate signed by the issuer. The optional
+// JSON body gives the RFC 5280 reason, such as {"reason": "keyCompromise"}.
+// The certificate is left revoked and pending, unless the reissue query is
+// true, and the resulting plan returned.
+func (a *API) RevokeHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	vars := mux.Vars(req)
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&body)
+	if err == io.EOF {
+		err = nil
+	}
+	reissue := false
+	if err == nil && req.URL.Query().Get("reissue") != "" {
+		if reissue, err = strconv.ParseBool(req.URL.Query().Get("reissue")); err != nil {
+			err = fmt.Errorf("invalid reissue %q", req.URL.Query().Get("reissue"))
+		}
+	}
+	if err == nil {
+		err = a.cfg.Revoke(vars["issuer"], vars["name"], body.Reason)
+	}
+	if err != nil {
+		status := http.StatusBadRequest
+		switch err {
+		case config.ErrNotFound:
+			status = http.StatusNotFound
+		case config.ErrRevoked:
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+	if reissue {
+		if err := a.cfg.Reissue(vars["name"]); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("revoked, but failed reissuing: %s", err)}); err != nil {
+				panic(err)
+			}
+			return
+		}
+	}
+	a.reconcile(w, "revoked")
+}
+
+// reconcile issues what changed after done succeeded and responds with the
+// resulting plan.
+func (a *API) reconcile(w http.ResponseWriter, done string) {
+	var err error
+	if a.Reloader != nil {
+		err = a.Reloader.Reload()
+	} else {
+		_, err = a.cfg.Init()
+	}
+	var plan *config.Plan
+	if err == nil {
+		plan, err = a.cfg.Plan()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s, but failed reconciling: %s", done, err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(plan); err != nil {
+		panic(err)
+	}
+}
+
+// OCSPHandler is the OCSP responder of the CAs, answering RFC 6960 requests
+// POSTed as the body or base64 encoded in the path of GET requests. Errors
+// are answered with OCSP error responses.
+func (a *API) OCSPHandler(w http.ResponseWriter, req *http.Request) {
+	var raw []byte
+	var err error
+	if req.Method == "GET" {
+		raw, err = base64.StdEncoding.DecodeString(mux.Vars(req)["request"])
+	} else {
+		raw, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 1<<16))
+	}
+	var resp []byte
+	if err != nil {
+		err = config.ErrMalformedOCSP
+	} else {
+		resp, err = a.cfg.OCSP(raw)
+	}
+	switch err {
+	case nil:
+	case config.ErrNotFound:
+		resp = ocsp.UnauthorizedErrorResponse
+	case config.ErrMalformedOCSP:
+		resp = ocsp.MalformedRequestErrorResponse
+	default:
+		log.Printf("Failed answering OCSP request: %v", err)
+		resp = ocsp.InternalErrorErrorResponse
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	// Caches keep GET responses for a few minutes only, so revocations
+	// show well before the responses expire.
+	if req.Method == "GET" && err == nil {
+		w.Header().Set("Cache-Control", "max-age=300, public, no-transform, must-revalidate")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// CRLHandler returns the CRL of a CA, DER encoded as its CRL distribution
+// point serves it, or PEM encoded from crl.pem.
+func (a *API) CRLHandler(w http.ResponseWriter, req *http.Request) {
+	issuer := mux.Vars(req)["issuer"]
+
+	crl, err := a.cfg.CRL(issuer)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == config.ErrNotFound {
+			status = http.StatusNotFound
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(ErrorResp{Error: fmt.Sprintf("%s", err)}); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, ".pem") {
+		w.Header().Set("Content-Type", "application/x-pem-file; charset=UTF-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.crl.pem", issuer))
+		w.WriteHeader(http.StatusOK)
+		if err := pem.Encode(w, &pem.Block{
+			Bytes: crl,
+			Type:  "X509 CRL",
 		}); err != nil {
-			log.Fatalf("Failed ecoding %v certificate: %v", c.Name, err)
+			log.Printf("Failed ecoding %v CRL: %v", issuer, err)
 		}
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.crl", issuer))
+	w.WriteHeader(http.StatusOK)
+	w.Write(crl)
 }
 
 func (a *API) walk(node config.TreeNode, req *http.Request) LightWeightCertificate {
 	conf := node.Self()
 
 	var err error
-	var bundle *certificate.Bundle
+	var bundle *config.Bundle
 	var href *url.URL
 
 	if conf.IsCA {
-		bundle, err = a.cfg.EasyPKI.GetCA(conf.Name)
+		bundle, err = a.cfg.GetCA(conf.Name)
 		href, _ = a.r.Get(string(CAInfo)).URL("issuer", conf.Name)
 	} else {
-		bundle, err = a.cfg.EasyPKI.GetBundle(conf.Signer, conf.Name)
+		bundle, err = a.cfg.GetBundle(conf.Signer, conf.Name)
 		href, _ = a.r.Get(string(CertInfo)).URL("issuer", conf.Signer, "name", conf.Name)
 	}
 	if err != nil {
 		return LightWeightCertificate{
-			Name: conf.Name,
+			Name:   conf.Name,
+			Source: conf.Source.String(),
 		}
 	}
 
@@ -271,6 +884,7 @@ func (a *API) walk(node config.TreeNode, req *http.Request) LightWeightCertifica
 		NotAfter:   bundle.Cert.NotAfter,
 		NotBefore:  bundle.Cert.NotBefore,
 		Issuer:     bundle.Cert.Issuer.CommonName,
+		Source:     conf.Source.String(),
 
 		Href: decorateUrl(href, req).String(),
 
@@ -302,8 +916,8 @@ func decorateUrl(u *url.URL, req *http.Request) *url.URL {
 
 // get retrieves a bundle from the bolt database. If fullChain is true, the
 // certificate will be the chain of trust from the primary tup to root CA.
-func get(pki *easypki.EasyPKI, caName, bundleName string, fullChain bool) {
-	var bundle *certificate.Bundle
+func get(pki *config.Config, caName, bundleName string, fullChain bool) {
+	var bundle *config.Bundle
 	if caName == "" {
 		caName = bundleName
 	}
@@ -312,7 +926,7 @@ func get(pki *easypki.EasyPKI, caName, bundleName string, fullChain bool) {
 		log.Fatalf("Failed getting bundle %v within CA %v: %v", bundleName, caName, err)
 	}
 	leaf := bundle
-	chain := []*certificate.Bundle{bundle}
+	chain := []*config.Bundle{bundle}
 	if fullChain {
 		for {
 			if leaf.Cert.Issuer.CommonName == leaf.Cert.Subject.CommonName {
@@ -326,15 +940,19 @@ func get(pki *easypki.EasyPKI, caName, bundleName string, fullChain bool) {
 			leaf = ca
 		}
 	}
-	key, err := os.Create(bundleName + ".key")
-	if err != nil {
-		log.Fatalf("Failed creating key output file: %v", err)
-	}
-	if err := pem.Encode(key, &pem.Block{
-		Bytes: x509.MarshalPKCS1PrivateKey(bundle.Key),
-		Type:  "RSA PRIVATE KEY",
-	}); err != nil {
-		log.Fatalf("Failed ecoding private key: %v", err)
+	// Certificates issued for a request come without their key.
+	if bundle.Key != nil {
+		key, err := os.Create(bundleName + ".key")
+		if err != nil {
+			log.Fatalf("Failed creating key output file: %v", err)
+		}
+		block, err := config.PrivateKeyPEM(bundle.Key)
+		if err != nil {
+			log.Fatalf("Failed ecoding private key: %v", err)
+		}
+		if err := pem.Encode(key, block); err != nil {
+			log.Fatalf("Failed ecoding private key: %v", err)
+		}
 	}
 	crtName := bundleName + ".crt"
 	if fullChain {
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"easypki-ui/config"
+	"github.com/boltdb/bolt"
+	"github.com/google/easypki/pkg/easypki"
+	"github.com/google/easypki/pkg/store"
+	"github.com/gorilla/mux"
+)
+
+// testServer serves the API, mounted like main does, for a configuration
+// holding a single root CA.
+func testServer(t *testing.T, anonymous bool) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pki.yml")
+	err := ioutil.WriteFile(path, []byte(`certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "pki.boltdb"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := config.Config{
+		Store:   &config.Yaml{Path: path},
+		EasyPKI: &easypki.EasyPKI{Store: &config.BoltPKI{Bolt: store.Bolt{DB: db}}},
+	}
+	if _, err := cfg.Init(); err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	(&API{Anonymous: anonymous}).Setup(&cfg, r.PathPrefix("/api").Subrouter())
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func post(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestCreateCertificate(t *testing.T) {
+	srv := testServer(t, true)
+	web := `{"name": "web", "commonName": "web.example.com", "signer": "Root CA", "dnsNames": ["web.example.com"], "expire": "720h"}`
+
+	resp := post(t, srv.URL+"/api/certificates", web)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST /api/certificates = %v, want %v", resp.Status, http.StatusCreated)
+	}
+	location := resp.Header.Get("Location")
+	if want := srv.URL + "/api/Root%20CA/web"; location != want {
+		t.Errorf("Location = %q, want %q", location, want)
+	}
+	var cert Certificate
+	if err := json.NewDecoder(resp.Body).Decode(&cert); err != nil {
+		t.Fatal(err)
+	}
+	if cert.Name != "web" || cert.Subject.CommonName != "web.example.com" || cert.Href != location {
+		t.Errorf("created certificate = %v %q at %v, want web.example.com at %v", cert.Name, cert.Subject.CommonName, cert.Href, location)
+	}
+
+	got, err := http.Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Body.Close()
+	if got.StatusCode != http.StatusOK {
+		t.Errorf("GET %v = %v, want %v", location, got.Status, http.StatusOK)
+	}
+
+	for _, test := range []struct {
+		name, body string
+		status     int
+	}{
+		{"existing", web, http.StatusConflict},
+		{"undefined signer", `{"name": "api", "commonName": "api", "signer": "Missing CA"}`, http.StatusBadRequest},
+		{"unknown field", `{"name": "api", "commonName": "api", "signer": "Root CA", "cn": "api"}`, http.StatusBadRequest},
+		{"not json", `name: api`, http.StatusBadRequest},
+	} {
+		resp := post(t, srv.URL+"/api/certificates", test.body)
+		var e ErrorResp
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			t.Errorf("%v: error body = %+v, %v", test.name, e, err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%v: POST /api/certificates = %v (%v), want %v", test.name, resp.Status, e.Error, test.status)
+		}
+	}
+}
+
+func TestCreateCertificateRequiresUser(t *testing.T) {
+	srv := testServer(t, false)
+	resp := post(t, srv.URL+"/api/certificates", `{"name": "web", "commonName": "web", "signer": "Root CA"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous POST /api/certificates = %v, want %v", resp.Status, http.StatusUnauthorized)
+	}
+}
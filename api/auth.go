@@ -15,6 +15,8 @@ import (
 	"sort"
 	"github.com/lestrrat-go/jwx/jwa"
 		"context"
+	"io/ioutil"
+	"log"
 )
 
 type DiscoveryMetadata struct {
@@ -108,14 +110,18 @@ func (p *Provider) getMetadata(c *http.Client) error {
 	if err != nil {
 		return err
 	}
+	defer r.Body.Close()
 
 	if r.StatusCode != 200 {
 		return fmt.Errorf("error retreiving discovery metadata %d: %s", r.StatusCode, r.Status)
 	}
 
-	var b []byte
-	r.Body.Read(b)
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
 
+	p.metadata = &DiscoveryMetadata{}
 	err = json.Unmarshal(b, p.metadata)
 	if err != nil {
 		return err
@@ -129,13 +135,16 @@ func (p *Provider) getCertificates(c *http.Client) error {
 	if err != nil {
 		return err
 	}
+	defer r.Body.Close()
 
 	if r.StatusCode != 200 {
 		return fmt.Errorf("error retreiving jwks %d: %s", r.StatusCode, r.Status)
 	}
 
-	var b []byte
-	r.Body.Read(b)
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
 
 	p.jwks, err = jwk.Parse(b)
 	if err != nil {
@@ -145,12 +154,21 @@ func (p *Provider) getCertificates(c *http.Client) error {
 	return nil
 }
 
-func (p *Provider) Setup(c *http.Client) {
-	p.getMetadata(c)
-	p.getCertificates(c)
+func (p *Provider) Setup(c *http.Client) error {
+	if err := p.getMetadata(c); err != nil {
+		return err
+	}
+
+	return p.getCertificates(c)
 }
 
+// tokenSkew is how far the clocks of identity providers may be off when
+// checking when tokens expire or become valid.
+const tokenSkew = time.Minute
+
 type Providers struct {
+	// ClientID is the audience tokens must be issued for.
+	ClientID  string
 	providers []*Provider
 }
 func (p *Providers) find(issuer string) *Provider {
@@ -165,11 +183,17 @@ func (p *Providers) find(issuer string) *Provider {
 }
 
 func (p *Providers) setup(c *http.Client) {
+	var ready []*Provider
 	for _, provider := range p.providers {
-		provider.Setup(c)
+		if err := provider.Setup(c); err != nil {
+			log.Printf("Failed setting up identity provider %v: %v", provider.discovery.String(), err)
+			continue
+		}
+		ready = append(ready, provider)
 	}
+	p.providers = ready
 	sort.Slice(p.providers, func(i, j int) bool {
-		return p.providers[i].metadata.Issuer < p.providers[i].metadata.Issuer
+		return p.providers[i].metadata.Issuer < p.providers[j].metadata.Issuer
 	})
 }
 
@@ -178,6 +202,11 @@ func (p *Providers) Add(metadataUrl url.URL) {
 }
 
 func Verify(providers Providers, raw string) (bool, string) {
+	// Without an audience to check any token of the providers would do.
+	if providers.ClientID == "" {
+		return false, ""
+	}
+
 	sectionsB64 := strings.Split(raw, ".")
 	// Verify we have the expected number of JWT bits
 	if len(sectionsB64) != 3 {
@@ -191,13 +220,16 @@ func Verify(providers Providers, raw string) (bool, string) {
 	}
 
 	var header JwtHeader
-	err = json.Unmarshal(headerJson, header)
+	err = json.Unmarshal(headerJson, &header)
 	if err != nil {
 		return false, ""
 	}
 
 	// Parse the token without verification so that we can find the provider that matches the issuer
-	parsedToken, err := jwt.ParseString(raw, nil)
+	parsedToken, err := jwt.ParseString(raw)
+	if err != nil {
+		return false, ""
+	}
 	provider := providers.find(parsedToken.Issuer())
 	if provider != nil {
 		// JWT signing algorithm
@@ -215,11 +247,26 @@ func Verify(providers Providers, raw string) (bool, string) {
 			}
 		}
 
-		_, err := jwt.ParseVerify(strings.NewReader(raw), algorithm, k[0])
+		if len(k) == 0 {
+			return false, ""
+		}
+
+		key, err := k[0].Materialize()
 		if err != nil {
 			return false, ""
 		}
 
+		// ParseVerify only checks the signature, the claims are checked
+		// separately so tokens issued for other clients or expired ones
+		// are rejected as well.
+		token, err := jwt.ParseVerify(strings.NewReader(raw), algorithm, key)
+		if err != nil {
+			return false, ""
+		}
+		if err := token.Verify(jwt.WithAudience(providers.ClientID), jwt.WithAcceptableSkew(tokenSkew)); err != nil {
+			return false, ""
+		}
+
 		bodyJson, _ := base64.RawURLEncoding.DecodeString(sectionsB64[1])
 		return true, string(bodyJson)
 	}
@@ -247,3 +294,30 @@ func AuthMiddleware(providers Providers) mux.MiddlewareFunc {
 		})
 	}
 }
+
+// RequireUser rejects requests AuthMiddleware did not authenticate, guarding
+// the routes that change the PKI.
+func RequireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Context().Value("user") == nil {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusUnauthorized)
+			if err := json.NewEncoder(w).Encode(ErrorResp{Error: "authentication required"}); err != nil {
+				panic(err)
+			}
+			return
+		}
+
+		next(w, req)
+	}
+}
+
+// requireUser guards next with RequireUser unless anonymous changes are
+// allowed.
+func (a *API) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	if a.Anonymous {
+		return next
+	}
+
+	return RequireUser(next)
+}
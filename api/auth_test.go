@@ -0,0 +1,93 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+const testIssuer = "https://id.example.com"
+
+func testProviders(t *testing.T, clientID string) (Providers, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := jwk.New(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Providers{ClientID: clientID, providers: []*Provider{{
+		metadata: &DiscoveryMetadata{Issuer: testIssuer},
+		jwks:     &jwk.Set{Keys: []jwk.Key{pub}},
+	}}}, key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	token := jwt.New()
+	for k, v := range claims {
+		if err := token.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := token.Sign(jwa.RS256, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(raw)
+}
+
+func TestVerify(t *testing.T) {
+	providers, key := testProviders(t, "easypki")
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{"valid", map[string]interface{}{"aud": "easypki", "exp": now.Add(time.Hour).Unix()}, true},
+		{"within skew", map[string]interface{}{"aud": "easypki", "exp": now.Add(-tokenSkew / 2).Unix()}, true},
+		{"other audience", map[string]interface{}{"aud": "other", "exp": now.Add(time.Hour).Unix()}, false},
+		{"no audience", map[string]interface{}{"exp": now.Add(time.Hour).Unix()}, false},
+		{"expired", map[string]interface{}{"aud": "easypki", "exp": now.Add(-time.Hour).Unix()}, false},
+		{"not yet valid", map[string]interface{}{"aud": "easypki", "nbf": now.Add(time.Hour).Unix()}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.claims["iss"] = testIssuer
+			test.claims["sub"] = "alice"
+			if ok, _ := Verify(providers, signToken(t, key, test.claims)); ok != test.ok {
+				t.Errorf("Verify() = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	providers, key := testProviders(t, "easypki")
+	claims := map[string]interface{}{"iss": testIssuer, "aud": "easypki", "exp": time.Now().Add(time.Hour).Unix()}
+	raw := signToken(t, key, claims)
+
+	_, other := testProviders(t, "easypki")
+	if ok, _ := Verify(providers, signToken(t, other, claims)); ok {
+		t.Error("Verify() accepted a token signed by another key")
+	}
+	claims["iss"] = "https://other.example.com"
+	if ok, _ := Verify(providers, signToken(t, key, claims)); ok {
+		t.Error("Verify() accepted a token of an unknown issuer")
+	}
+	if ok, _ := Verify(Providers{providers: providers.providers}, raw); ok {
+		t.Error("Verify() accepted a token without a client ID to check")
+	}
+	if ok, body := Verify(providers, raw); !ok || body == "" {
+		t.Errorf("Verify() = %v, %q, want the claims of a valid token", ok, body)
+	}
+}
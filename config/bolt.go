@@ -0,0 +1,362 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	configBucketKey   = []byte("easypki-ui/config")
+	certsBucketKey    = []byte("certs")
+	signersBucketKey  = []byte("signers")
+	profilesBucketKey = []byte("profiles")
+	seededKey         = []byte("seeded")
+)
+
+// Bolt is a Store keeping certificate definitions in the same bolt database as
+// the issued bundles.
+//
+// Definitions are stored JSON encoded in the certs bucket, keyed by name. The
+// signers bucket holds one nested bucket per signer listing the names it
+// signs, which Tree uses to find children without scanning every definition.
+// Profiles are stored JSON encoded in the profiles bucket.
+type Bolt struct {
+	DB *bolt.DB
+}
+
+// buckets returns respectively the certs and signers buckets, creating them
+// in writable transactions.
+func (b *Bolt) buckets(tx *bolt.Tx) (*bolt.Bucket, *bolt.Bucket, error) {
+	if !tx.Writable() {
+		root := tx.Bucket(configBucketKey)
+		if root == nil {
+			return nil, nil, nil
+		}
+		return root.Bucket(certsBucketKey), root.Bucket(signersBucketKey), nil
+	}
+
+	root, err := tx.CreateBucketIfNotExists(configBucketKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed getting %s bucket: %v", configBucketKey, err)
+	}
+	cb, err := root.CreateBucketIfNotExists(certsBucketKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed getting %s certs bucket: %v", configBucketKey, err)
+	}
+	sb, err := root.CreateBucketIfNotExists(signersBucketKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed getting %s signers bucket: %v", configBucketKey, err)
+	}
+
+	return cb, sb, nil
+}
+
+func getCert(cb *bolt.Bucket, name string) (*Cert, error) {
+	if cb == nil {
+		return nil, nil
+	}
+	v := cb.Get([]byte(name))
+	if v == nil {
+		return nil, nil
+	}
+	cert := &Cert{}
+	if err := json.Unmarshal(v, cert); err != nil {
+		return nil, fmt.Errorf("failed decoding certificate %v: %v", name, err)
+	}
+
+	return cert, nil
+}
+
+func putCert(cb, sb *bolt.Bucket, cert Cert) error {
+	v, err := json.Marshal(cert)
+	if err != nil {
+		return fmt.Errorf("failed encoding certificate %v: %v", cert.Name, err)
+	}
+	if err := cb.Put([]byte(cert.Name), v); err != nil {
+		return fmt.Errorf("failed putting certificate %v: %v", cert.Name, err)
+	}
+
+	// Self signed roots have no signer to be indexed under.
+	if cert.Signer == "" {
+		return nil
+	}
+	ib, err := sb.CreateBucketIfNotExists([]byte(cert.Signer))
+	if err != nil {
+		return fmt.Errorf("failed getting signer index for %v: %v", cert.Signer, err)
+	}
+	if err := ib.Put([]byte(cert.Name), []byte{}); err != nil {
+		return fmt.Errorf("failed indexing certificate %v: %v", cert.Name, err)
+	}
+
+	return nil
+}
+
+func deleteCert(cb, sb *bolt.Bucket, cert Cert) error {
+	if err := cb.Delete([]byte(cert.Name)); err != nil {
+		return fmt.Errorf("failed deleting certificate %v: %v", cert.Name, err)
+	}
+	if cert.Signer == "" {
+		return nil
+	}
+	if ib := sb.Bucket([]byte(cert.Signer)); ib != nil {
+		if err := ib.Delete([]byte(cert.Name)); err != nil {
+			return fmt.Errorf("failed unindexing certificate %v: %v", cert.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// children returns the names indexed under signer.
+func children(sb *bolt.Bucket, signer string) []string {
+	if sb == nil {
+		return nil
+	}
+	ib := sb.Bucket([]byte(signer))
+	if ib == nil {
+		return nil
+	}
+
+	var names []string
+	ib.ForEach(func(k, _ []byte) error {
+		if string(k) != signer {
+			names = append(names, string(k))
+		}
+		return nil
+	})
+
+	return names
+}
+
+func (b *Bolt) Add(cert Cert) error {
+	if cert.Name == "" {
+		return fmt.Errorf("cannot add a certificate without a name")
+	}
+
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, sb, err := b.buckets(tx)
+		if err != nil {
+			return err
+		}
+		if cb.Get([]byte(cert.Name)) != nil {
+			return fmt.Errorf("cannot add %v: %w", cert.Name, ErrExists)
+		}
+
+		return putCert(cb, sb, cert)
+	})
+}
+
+func (b *Bolt) Get(name string) (*Cert, error) {
+	var cert *Cert
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, _, err := b.buckets(tx)
+		if err != nil {
+			return err
+		}
+		cert, err = getCert(cb, name)
+		return err
+	})
+
+	return cert, err
+}
+
+func (b *Bolt) Update(name string, cert Cert) error {
+	if cert.Name == "" {
+		return fmt.Errorf("cannot update %v to a certificate without a name", name)
+	}
+
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, sb, err := b.buckets(tx)
+		if err != nil {
+			return err
+		}
+		old, err := getCert(cb, name)
+		if err != nil {
+			return err
+		}
+		if old == nil {
+			return fmt.Errorf("cannot update %v: %w", name, ErrNotFound)
+		}
+		if cert.Name != name {
+			if cb.Get([]byte(cert.Name)) != nil {
+				return fmt.Errorf("cannot rename %v to %v: %w", name, cert.Name, ErrExists)
+			}
+			if len(children(sb, name)) > 0 {
+				return fmt.Errorf("cannot rename %v: %w", name, ErrHasChildren)
+			}
+		}
+
+		if err := deleteCert(cb, sb, *old); err != nil {
+			return err
+		}
+
+		return putCert(cb, sb, cert)
+	})
+}
+
+func (b *Bolt) Delete(name string, cascade bool) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, sb, err := b.buckets(tx)
+		if err != nil {
+			return err
+		}
+		cert, err := getCert(cb, name)
+		if err != nil {
+			return err
+		}
+		if cert == nil {
+			return fmt.Errorf("cannot delete %v: %w", name, ErrNotFound)
+		}
+		if len(children(sb, name)) > 0 && !cascade {
+			return fmt.Errorf("cannot delete %v: %w", name, ErrHasChildren)
+		}
+
+		queue := []Cert{*cert}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			for _, child := range children(sb, c.Name) {
+				cc, err := getCert(cb, child)
+				if err != nil {
+					return err
+				}
+				if cc != nil {
+					queue = append(queue, *cc)
+				}
+			}
+			if err := deleteCert(cb, sb, c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *Bolt) List(filter Filter) ([]Cert, error) {
+	var certs []Cert
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, sb, err := b.buckets(tx)
+		if err != nil || cb == nil {
+			return err
+		}
+
+		add := func(v []byte) error {
+			var cert Cert
+			if err := json.Unmarshal(v, &cert); err != nil {
+				return fmt.Errorf("failed decoding certificate: %v", err)
+			}
+			if filter.Match(cert) {
+				certs = append(certs, cert)
+			}
+			return nil
+		}
+
+		// Keys are iterated in byte order, so results come out sorted by name.
+		if filter.Signer == "" {
+			return cb.ForEach(func(_, v []byte) error {
+				return add(v)
+			})
+		}
+		ib := sb.Bucket([]byte(filter.Signer))
+		if ib == nil {
+			return nil
+		}
+		return ib.ForEach(func(k, _ []byte) error {
+			if v := cb.Get(k); v != nil {
+				return add(v)
+			}
+			return nil
+		})
+	})
+
+	return certs, err
+}
+
+// Tree loads every definition at once and arranges them below their roots,
+// so reading the database fails here rather than while walking the tree.
+func (b *Bolt) Tree() ([]TreeNode, error) {
+	certs, err := b.List(Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTree(certs), nil
+}
+
+// Profiles returns the profiles copied from the seeding configuration.
+func (b *Bolt) Profiles() (map[string]Profile, error) {
+	profiles := map[string]Profile{}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(configBucketKey)
+		if root == nil {
+			return nil
+		}
+		pb := root.Bucket(profilesBucketKey)
+		if pb == nil {
+			return nil
+		}
+		return pb.ForEach(func(k, v []byte) error {
+			var p Profile
+			if err := json.Unmarshal(v, &p); err != nil {
+				return fmt.Errorf("failed decoding profile %s: %v", k, err)
+			}
+			profiles[string(k)] = p
+			return nil
+		})
+	})
+
+	return profiles, err
+}
+
+// Seed copies every definition, and the profiles when from has any, from the
+// given store unless the database has been seeded before. It reports whether
+// anything was copied.
+func (b *Bolt) Seed(from Store) (bool, error) {
+	certs, err := from.List(Filter{})
+	if err != nil {
+		return false, fmt.Errorf("failed listing certificates to seed: %v", err)
+	}
+	profiles, err := definedProfiles(from)
+	if err != nil {
+		return false, err
+	}
+
+	seeded := false
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		cb, sb, err := b.buckets(tx)
+		if err != nil {
+			return err
+		}
+		root := tx.Bucket(configBucketKey)
+		if root.Get(seededKey) != nil {
+			return nil
+		}
+
+		for _, cert := range certs {
+			if err := putCert(cb, sb, cert); err != nil {
+				return err
+			}
+		}
+		pb, err := root.CreateBucketIfNotExists(profilesBucketKey)
+		if err != nil {
+			return fmt.Errorf("failed getting %s profiles bucket: %v", configBucketKey, err)
+		}
+		for name, p := range profiles {
+			v, err := json.Marshal(p)
+			if err != nil {
+				return fmt.Errorf("failed encoding profile %v: %v", name, err)
+			}
+			if err := pb.Put([]byte(name), v); err != nil {
+				return fmt.Errorf("failed putting profile %v: %v", name, err)
+			}
+		}
+		seeded = true
+
+		return root.Put(seededKey, []byte{1})
+	})
+
+	return seeded, err
+}
@@ -0,0 +1,128 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+	"easypki-ui/config/storetest"
+	"github.com/boltdb/bolt"
+	"github.com/google/easypki/pkg/store"
+)
+
+func openBolt(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "pki.boltdb"), 0600, nil)
+	if err != nil {
+		t.Fatalf("failed opening bolt database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestBoltStore(t *testing.T) {
+	err := storetest.TestStore(func() (config.Store, error) {
+		return &config.Bolt{DB: openBolt(t)}, nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// seedFile writes a configuration with a root, a leaf and a profile and
+// returns the store reading it.
+func seedFile(t *testing.T) *config.Yaml {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pki.yml")
+	data := `profiles:
+  short:
+    expire: 24h
+certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+  - name: web
+    commonName: web.acme.internal
+    signer: Root CA
+    profile: short
+`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &config.Yaml{Path: path}
+}
+
+// seeder is implemented by the database stores.
+type seeder interface {
+	config.Store
+	config.ProfileSource
+	Seed(from config.Store) (bool, error)
+}
+
+func checkSeed(t *testing.T, s seeder) {
+	t.Helper()
+	from := seedFile(t)
+	if seeded, err := s.Seed(from); err != nil || !seeded {
+		t.Fatalf("Seed of empty store = %v, %v; want true, nil", seeded, err)
+	}
+	certs, err := s.List(config.Filter{})
+	if err != nil || len(certs) != 2 {
+		t.Fatalf("List after Seed = %v, %v; want 2 certificates", certs, err)
+	}
+	profiles, err := s.Profiles()
+	if err != nil || profiles["short"].Expire != config.Duration(24*time.Hour) {
+		t.Errorf("Profiles after Seed = %v, %v; want short", profiles, err)
+	}
+
+	if err := s.Delete("web", false); err != nil {
+		t.Fatal(err)
+	}
+	if seeded, err := s.Seed(from); err != nil || seeded {
+		t.Errorf("second Seed = %v, %v; want false, nil", seeded, err)
+	}
+	if cert, err := s.Get("web"); err != nil || cert != nil {
+		t.Errorf("second Seed brought back deleted web: %v, %v", cert, err)
+	}
+}
+
+func TestBoltSeed(t *testing.T) {
+	checkSeed(t, &config.Bolt{DB: openBolt(t)})
+}
+
+// Definitions share the database with the bundles and must not be taken
+// for any.
+func TestBoltBesideBundles(t *testing.T) {
+	db := openBolt(t)
+	if seeded, err := (&config.Bolt{DB: db}).Seed(seedFile(t)); err != nil || !seeded {
+		t.Fatalf("Seed = %v, %v", seeded, err)
+	}
+	refs, err := (&config.BoltPKI{Bolt: store.Bolt{DB: db}}).Bundles()
+	if err != nil || len(refs) != 0 {
+		t.Errorf("Bundles = %v, %v; want none", refs, err)
+	}
+}
+
+// A definition that cannot be read fails loading the tree instead of
+// leaving it out.
+func TestBoltTreeError(t *testing.T) {
+	db := openBolt(t)
+	s := &config.Bolt{DB: db}
+	if err := s.Add(config.Cert{Name: "Root CA", CommonName: "Root CA", IsCA: true, Expire: config.Duration(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("easypki-ui/config")).Bucket([]byte("certs")).Put([]byte("web"), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if roots, err := s.Tree(); err == nil {
+		t.Errorf("Tree = %v, nil; want an error", roots)
+	}
+}
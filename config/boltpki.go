@@ -0,0 +1,358 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/easypki/pkg/store"
+)
+
+var (
+	pkiKeysBucketKey  = []byte("keys")
+	pkiCertsBucketKey = []byte("certs")
+)
+
+// BoltPKI is the easypki bolt store, extended with the operations easypki
+// itself does not offer.
+type BoltPKI struct {
+	store.Bolt
+}
+
+// Bundles lists every bundle in the database. CA buckets are recognised by
+// their keys and certs buckets.
+func (b *BoltPKI) Bundles() ([]BundleRef, error) {
+	var refs []BundleRef
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, root *bolt.Bucket) error {
+			if bytes.Equal(name, configBucketKey) || root.Bucket(pkiKeysBucketKey) == nil {
+				return nil
+			}
+			cb := root.Bucket(pkiCertsBucketKey)
+			if cb == nil {
+				return nil
+			}
+			return cb.ForEach(func(k, _ []byte) error {
+				refs = append(refs, BundleRef{CA: string(name), Name: string(k)})
+				return nil
+			})
+		})
+	})
+
+	return refs, err
+}
+
+// Fetch returns the key and certificate of a bundle like the easypki store,
+// but copied within the transaction: the store returns memory bolt may remap
+// on the next write, which certificates parsed from it keep pointing to.
+func (b *BoltPKI) Fetch(caName, name string) ([]byte, []byte, error) {
+	var key, cert []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(caName))
+		if root == nil {
+			return fmt.Errorf("%v bucket does not exist", caName)
+		}
+		kb, cb := root.Bucket(pkiKeysBucketKey), root.Bucket(pkiCertsBucketKey)
+		if kb == nil || cb == nil {
+			return fmt.Errorf("%v keys or certs bucket does not exist", caName)
+		}
+		k, c := kb.Get([]byte(name)), cb.Get([]byte(name))
+		if k == nil || c == nil {
+			return store.ErrDoesNotExist
+		}
+		key, cert = append([]byte(nil), k...), append([]byte(nil), c...)
+		return nil
+	})
+
+	return key, cert, err
+}
+
+var (
+	externalBucketKey = []byte("easypki-ui/external")
+	requestsBucketKey = []byte("requests")
+	chainsBucketKey   = []byte("chains")
+	requestKeyKey     = []byte("key")
+	requestCSRKey     = []byte("csr")
+)
+
+// external returns the named bucket within the external bucket, creating
+// them in writable transactions.
+func external(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	if !tx.Writable() {
+		root := tx.Bucket(externalBucketKey)
+		if root == nil {
+			return nil, nil
+		}
+		return root.Bucket(name), nil
+	}
+
+	root, err := tx.CreateBucketIfNotExists(externalBucketKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting %s bucket: %v", externalBucketKey, err)
+	}
+	b, err := root.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting %s %s bucket: %v", externalBucketKey, name, err)
+	}
+
+	return b, nil
+}
+
+// PutRequest stores the pending request of an externally signed CA, in a
+// bucket of its own within the requests bucket.
+func (b *BoltPKI) PutRequest(name string, key, csr []byte) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		rb, err := external(tx, requestsBucketKey)
+		if err != nil {
+			return err
+		}
+		nb, err := rb.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return fmt.Errorf("failed getting request bucket of %v: %v", name, err)
+		}
+		if err := nb.Put(requestKeyKey, key); err != nil {
+			return err
+		}
+		return nb.Put(requestCSRKey, csr)
+	})
+}
+
+func (b *BoltPKI) Request(name string) ([]byte, []byte, error) {
+	var key, csr []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		rb, err := external(tx, requestsBucketKey)
+		if err != nil || rb == nil {
+			return err
+		}
+		nb := rb.Bucket([]byte(name))
+		if nb == nil {
+			return nil
+		}
+		// Values are only valid for the life of the transaction.
+		key = append([]byte(nil), nb.Get(requestKeyKey)...)
+		csr = append([]byte(nil), nb.Get(requestCSRKey)...)
+		return nil
+	})
+
+	return key, csr, err
+}
+
+func (b *BoltPKI) DeleteRequest(name string) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		rb, err := external(tx, requestsBucketKey)
+		if err != nil {
+			return err
+		}
+		if err := rb.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (b *BoltPKI) PutChain(name string, chain []byte) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, err := external(tx, chainsBucketKey)
+		if err != nil {
+			return err
+		}
+		return cb.Put([]byte(name), chain)
+	})
+}
+
+func (b *BoltPKI) Chain(name string) ([]byte, error) {
+	var chain []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, err := external(tx, chainsBucketKey)
+		if err != nil || cb == nil {
+			return err
+		}
+		if v := cb.Get([]byte(name)); v != nil {
+			chain = append([]byte(nil), v...)
+		}
+		return nil
+	})
+
+	return chain, err
+}
+
+var (
+	revocationsBucketKey = []byte("easypki-ui/revocations")
+	reasonsBucketKey     = []byte("reasons")
+	supersededBucketKey  = []byte("superseded")
+	crlKey               = []byte("crl")
+	responderKeyKey      = []byte("ocsp/key")
+	responderCertKey     = []byte("ocsp/cert")
+)
+
+// revocations returns the bucket of the CA caName within the revocations
+// bucket, creating them in writable transactions.
+func revocations(tx *bolt.Tx, caName string) (*bolt.Bucket, error) {
+	if !tx.Writable() {
+		root := tx.Bucket(revocationsBucketKey)
+		if root == nil {
+			return nil, nil
+		}
+		return root.Bucket([]byte(caName)), nil
+	}
+
+	root, err := tx.CreateBucketIfNotExists(revocationsBucketKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting %s bucket: %v", revocationsBucketKey, err)
+	}
+	b, err := root.CreateBucketIfNotExists([]byte(caName))
+	if err != nil {
+		return nil, fmt.Errorf("failed getting %s bucket of %v: %v", revocationsBucketKey, caName, err)
+	}
+
+	return b, nil
+}
+
+// PutReason stores the reason code as a single byte, keyed by the serial in
+// decimal.
+func (b *BoltPKI) PutReason(caName string, serial *big.Int, reason int) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil {
+			return err
+		}
+		rb, err := cb.CreateBucketIfNotExists(reasonsBucketKey)
+		if err != nil {
+			return fmt.Errorf("failed getting reasons bucket of %v: %v", caName, err)
+		}
+		return rb.Put([]byte(serial.String()), []byte{byte(reason)})
+	})
+}
+
+func (b *BoltPKI) Reasons(caName string) (map[string]int, error) {
+	reasons := map[string]int{}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil || cb == nil {
+			return err
+		}
+		rb := cb.Bucket(reasonsBucketKey)
+		if rb == nil {
+			return nil
+		}
+		return rb.ForEach(func(k, v []byte) error {
+			if len(v) == 1 {
+				reasons[string(k)] = int(v[0])
+			}
+			return nil
+		})
+	})
+
+	return reasons, err
+}
+
+func (b *BoltPKI) PutCRL(caName string, crl []byte) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil {
+			return err
+		}
+		return cb.Put(crlKey, crl)
+	})
+}
+
+func (b *BoltPKI) CRL(caName string) ([]byte, error) {
+	var crl []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil || cb == nil {
+			return err
+		}
+		if v := cb.Get(crlKey); v != nil {
+			crl = append([]byte(nil), v...)
+		}
+		return nil
+	})
+
+	return crl, err
+}
+
+func (b *BoltPKI) PutResponder(caName string, key, cert []byte) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil {
+			return err
+		}
+		if err := cb.Put(responderKeyKey, key); err != nil {
+			return err
+		}
+		return cb.Put(responderCertKey, cert)
+	})
+}
+
+func (b *BoltPKI) Responder(caName string) ([]byte, []byte, error) {
+	var key, cert []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil || cb == nil {
+			return err
+		}
+		if v := cb.Get(responderCertKey); v != nil {
+			key = append([]byte(nil), cb.Get(responderKeyKey)...)
+			cert = append([]byte(nil), v...)
+		}
+		return nil
+	})
+
+	return key, cert, err
+}
+
+// PutSuperseded stores notAfter in RFC 3339, keyed by the serial in decimal,
+// and drops the superseded certificates that expired meanwhile.
+func (b *BoltPKI) PutSuperseded(caName string, serial *big.Int, notAfter time.Time) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil {
+			return err
+		}
+		sb, err := cb.CreateBucketIfNotExists(supersededBucketKey)
+		if err != nil {
+			return fmt.Errorf("failed getting superseded bucket of %v: %v", caName, err)
+		}
+		now := time.Now()
+		var expired [][]byte
+		if err := sb.ForEach(func(k, v []byte) error {
+			if t, err := time.Parse(time.RFC3339, string(v)); err != nil || !now.Before(t) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := sb.Delete(k); err != nil {
+				return err
+			}
+		}
+		return sb.Put([]byte(serial.String()), []byte(notAfter.UTC().Format(time.RFC3339)))
+	})
+}
+
+func (b *BoltPKI) Superseded(caName string) (map[string]time.Time, error) {
+	superseded := map[string]time.Time{}
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		cb, err := revocations(tx, caName)
+		if err != nil || cb == nil {
+			return err
+		}
+		sb := cb.Bucket(supersededBucketKey)
+		if sb == nil {
+			return nil
+		}
+		return sb.ForEach(func(k, v []byte) error {
+			if t, err := time.Parse(time.RFC3339, string(v)); err == nil {
+				superseded[string(k)] = t
+			}
+			return nil
+		})
+	})
+
+	return superseded, err
+}
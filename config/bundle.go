@@ -0,0 +1,42 @@
+package config
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+)
+
+// Bundle is an issued certificate along with its private key. Unlike
+// easypki's certificate.Bundle the key is not necessarily RSA, and it is nil
+// for certificates issued for a certificate request.
+type Bundle struct {
+	Name string
+	Key  crypto.Signer
+	Cert *x509.Certificate
+}
+
+// GetCA returns the bundle of the named CA.
+func (c *Config) GetCA(name string) (*Bundle, error) {
+	return c.GetBundle(name, name)
+}
+
+// GetBundle returns the bundle name stored within the CA caName.
+func (c *Config) GetBundle(caName, name string) (*Bundle, error) {
+	rawKey, rawCert, err := c.EasyPKI.Store.Fetch(caName, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching bundle %v within CA %v: %v", name, caName, err)
+	}
+
+	var key crypto.Signer
+	if len(rawKey) > 0 {
+		if key, err = ParsePrivateKey(rawKey); err != nil {
+			return nil, fmt.Errorf("failed parsing key of bundle %v: %v", name, err)
+		}
+	}
+	cert, err := x509.ParseCertificate(rawCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing certificate of bundle %v: %v", name, err)
+	}
+
+	return &Bundle{Name: name, Key: key, Cert: cert}, nil
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NameConstraints limits the names CAs below a CA may issue certificates
+// for.
+type NameConstraints struct {
+	// Critical marks the extension critical, so relying parties that do not
+	// understand it reject the certificates instead of ignoring it.
+	Critical  bool    `yaml:"critical,omitempty" json:"critical,omitempty" toml:"critical,omitempty"`
+	Permitted NameSet `yaml:"permitted,omitempty" json:"permitted,omitempty" toml:"permitted,omitempty"`
+	Excluded  NameSet `yaml:"excluded,omitempty" json:"excluded,omitempty" toml:"excluded,omitempty"`
+}
+
+// NameSet lists names by kind. DNS and URI domains match the domain itself
+// and its subdomains, or only the subdomains with a leading "." or "*.".
+// Email entries are a mailbox, a domain such as "@acme.com", or ".acme.com"
+// for its subdomains. IP ranges are in CIDR notation.
+type NameSet struct {
+	DNSDomains     []string `yaml:"dnsDomains,omitempty" json:"dnsDomains,omitempty" toml:"dnsDomains,omitempty"`
+	EmailAddresses []string `yaml:"emailAddresses,omitempty" json:"emailAddresses,omitempty" toml:"emailAddresses,omitempty"`
+	IPRanges       []string `yaml:"ipRanges,omitempty" json:"ipRanges,omitempty" toml:"ipRanges,omitempty"`
+	URIDomains     []string `yaml:"uriDomains,omitempty" json:"uriDomains,omitempty" toml:"uriDomains,omitempty"`
+}
+
+// names is a NameSet in the form x509 encodes it.
+type names struct {
+	dns, email, uri []string
+	ip              []*net.IPNet
+}
+
+// domainConstraint converts the "*.example.com" form to the ".example.com"
+// form used in certificates.
+func domainConstraint(d string) string {
+	if strings.HasPrefix(d, "*.") {
+		return d[1:]
+	}
+
+	return d
+}
+
+func (s NameSet) parse() (names, error) {
+	var n names
+	for _, d := range s.DNSDomains {
+		if d == "" {
+			return n, fmt.Errorf("empty DNS domain")
+		}
+		n.dns = append(n.dns, domainConstraint(d))
+	}
+	for _, e := range s.EmailAddresses {
+		if e == "" || e == "@" {
+			return n, fmt.Errorf("empty email constraint")
+		}
+		n.email = append(n.email, strings.TrimPrefix(e, "@"))
+	}
+	for _, r := range s.IPRanges {
+		_, ipNet, err := net.ParseCIDR(r)
+		if err != nil {
+			return n, fmt.Errorf("invalid IP range %q: %v", r, err)
+		}
+		n.ip = append(n.ip, ipNet)
+	}
+	for _, d := range s.URIDomains {
+		if d == "" {
+			return n, fmt.Errorf("empty URI domain")
+		}
+		n.uri = append(n.uri, domainConstraint(d))
+	}
+
+	return n, nil
+}
+
+// apply sets the name constraints extension of tmpl.
+func (nc *NameConstraints) apply(tmpl *x509.Certificate) error {
+	if nc == nil {
+		return nil
+	}
+	permitted, err := nc.Permitted.parse()
+	if err != nil {
+		return fmt.Errorf("invalid permitted name constraints: %v", err)
+	}
+	excluded, err := nc.Excluded.parse()
+	if err != nil {
+		return fmt.Errorf("invalid excluded name constraints: %v", err)
+	}
+
+	tmpl.PermittedDNSDomainsCritical = nc.Critical
+	tmpl.PermittedDNSDomains = permitted.dns
+	tmpl.PermittedEmailAddresses = permitted.email
+	tmpl.PermittedIPRanges = permitted.ip
+	tmpl.PermittedURIDomains = permitted.uri
+	tmpl.ExcludedDNSDomains = excluded.dns
+	tmpl.ExcludedEmailAddresses = excluded.email
+	tmpl.ExcludedIPRanges = excluded.ip
+	tmpl.ExcludedURIDomains = excluded.uri
+
+	return nil
+}
+
+// sameNameConstraints reports whether the constraints in a and b are equal.
+func sameNameConstraints(a, b *x509.Certificate) bool {
+	return a.PermittedDNSDomainsCritical == b.PermittedDNSDomainsCritical &&
+		sameSet(a.PermittedDNSDomains, b.PermittedDNSDomains) &&
+		sameSet(a.PermittedEmailAddresses, b.PermittedEmailAddresses) &&
+		sameSet(ipNetStrings(a.PermittedIPRanges), ipNetStrings(b.PermittedIPRanges)) &&
+		sameSet(a.PermittedURIDomains, b.PermittedURIDomains) &&
+		sameSet(a.ExcludedDNSDomains, b.ExcludedDNSDomains) &&
+		sameSet(a.ExcludedEmailAddresses, b.ExcludedEmailAddresses) &&
+		sameSet(ipNetStrings(a.ExcludedIPRanges), ipNetStrings(b.ExcludedIPRanges)) &&
+		sameSet(a.ExcludedURIDomains, b.ExcludedURIDomains)
+}
+
+func ipNetStrings(nets []*net.IPNet) []string {
+	var values []string
+	for _, n := range nets {
+		values = append(values, n.String())
+	}
+
+	return values
+}
+
+// checkNameConstraints returns an error naming the first SAN of tmpl that the
+// name constraints of ca do not allow.
+func checkNameConstraints(tmpl, ca *x509.Certificate) error {
+	check := func(kind, name string, permitted, excluded []string, match func(name, constraint string) bool) error {
+		for _, c := range excluded {
+			if match(name, c) {
+				return fmt.Errorf("%v %v is excluded by the name constraints of %v", kind, name, ca.Subject.CommonName)
+			}
+		}
+		if len(permitted) == 0 {
+			return nil
+		}
+		for _, c := range permitted {
+			if match(name, c) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v %v is not permitted by the name constraints of %v", kind, name, ca.Subject.CommonName)
+	}
+
+	for _, name := range tmpl.DNSNames {
+		if err := check("DNS name", name, ca.PermittedDNSDomains, ca.ExcludedDNSDomains, matchDomain); err != nil {
+			return err
+		}
+	}
+	for _, name := range tmpl.EmailAddresses {
+		if err := check("email address", name, ca.PermittedEmailAddresses, ca.ExcludedEmailAddresses, matchEmail); err != nil {
+			return err
+		}
+	}
+	for _, uri := range tmpl.URIs {
+		if err := check("URI", uri.String(), ca.PermittedURIDomains, ca.ExcludedURIDomains, func(_, c string) bool {
+			return matchDomain(uri.Hostname(), c)
+		}); err != nil {
+			return err
+		}
+	}
+	for _, ip := range tmpl.IPAddresses {
+		if err := check("IP address", ip.String(), ipNetStrings(ca.PermittedIPRanges), ipNetStrings(ca.ExcludedIPRanges), func(_, c string) bool {
+			_, n, err := net.ParseCIDR(c)
+			return err == nil && n.Contains(ip)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchDomain reports whether name is within the domain constraint c: c
+// itself or a subdomain, or only subdomains when c starts with ".".
+func matchDomain(name, c string) bool {
+	name, c = strings.ToLower(name), strings.ToLower(c)
+	if strings.HasPrefix(c, ".") {
+		return strings.HasSuffix(name, c)
+	}
+
+	return name == c || strings.HasSuffix(name, "."+c)
+}
+
+// matchEmail reports whether the address is within the email constraint c: a
+// mailbox, a host, or the subdomains of a domain when c starts with ".".
+func matchEmail(address, c string) bool {
+	if strings.Contains(c, "@") {
+		return strings.EqualFold(address, c)
+	}
+	i := strings.LastIndex(address, "@")
+	if i < 0 {
+		return false
+	}
+	host := strings.ToLower(address[i+1:])
+	c = strings.ToLower(c)
+	if strings.HasPrefix(c, ".") {
+		return strings.HasSuffix(host, c)
+	}
+
+	return host == c
+}
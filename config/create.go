@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"log"
+)
+
+// Create adds the definition cert to the store and issues it right away,
+// signed by its already issued signer. The definition is checked together
+// with the stored ones; its problems are returned as ValidationErrors. When
+// issuing fails the definition is removed again.
+func (c *Config) Create(cert Cert) error {
+	c.issuing.Lock()
+	defer c.issuing.Unlock()
+
+	if existing, err := c.Store.Get(cert.Name); err != nil {
+		return err
+	} else if existing != nil {
+		return ErrExists
+	}
+	certs, err := c.Store.List(Filter{})
+	if err != nil {
+		return err
+	}
+	profiles, err := storeProfiles(c.Store)
+	if err != nil {
+		return err
+	}
+
+	var errs ValidationErrors
+	for _, e := range validateCerts(append(certs, cert), profiles) {
+		if e.Name == cert.Name {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if cert.External || cert.Imported {
+		return errors.New("externally signed and imported CAs cannot be issued")
+	}
+
+	byName := map[string]Cert{cert.Name: cert}
+	for _, def := range certs {
+		byName[def.Name] = def
+	}
+	resolved, _ := resolveSubjects(byName)
+	def := resolved[cert.Name]
+	p, err := resolveProfile(def, profiles)
+	if err != nil {
+		return err
+	}
+	// The chain lists the CAs above the certificate, nearest first, as far
+	// as they are defined.
+	var signer *Cert
+	var chain []string
+	if !selfSigned(def) {
+		s := resolved[def.Signer]
+		signer = &s
+		for ca, ok := byName[def.Signer]; ok; ca, ok = byName[ca.Signer] {
+			chain = append(chain, ca.Name)
+			if selfSigned(ca) {
+				break
+			}
+		}
+	}
+
+	if err := c.Store.Add(cert); err != nil {
+		return err
+	}
+	if err := c.makeCert(def, signer, p, chain); err != nil {
+		if err := c.Store.Delete(cert.Name, false); err != nil {
+			log.Printf("Failed removing definition of %v after failing to issue it: %v", cert.Name, err)
+		}
+		return err
+	}
+
+	return nil
+}
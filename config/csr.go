@@ -0,0 +1,112 @@
+package config
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ParseCSR reads a PEM or DER PKCS #10 certificate request and checks its
+// signature, which proves the requester holds the key.
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("expected a certificate request, got %v", block.Type)
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing certificate request: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %v", err)
+	}
+
+	return csr, nil
+}
+
+// SignProfile returns the profile of a certificate request signed by the CA
+// ca through the API that asks for the profile requested, or for none when
+// empty. Only the CA's signProfiles can be asked for.
+func SignProfile(ca Cert, requested string) (string, error) {
+	if requested == "" {
+		if len(ca.SignProfiles) == 0 {
+			return "", nil
+		}
+		return ca.SignProfiles[0], nil
+	}
+	if !contains(ca.SignProfiles, requested) {
+		return "", fmt.Errorf("%v does not sign requests with profile %v", ca.Name, requested)
+	}
+
+	return requested, nil
+}
+
+// RequestCert returns the definition of the certificate name, signed by
+// signer, for the subject, alternative names and key of csr. Other
+// extensions requested are left to the profile and the signer.
+func RequestCert(name, signer string, csr *x509.CertificateRequest) (Cert, error) {
+	s := csr.Subject
+	cert := Cert{
+		Name: name,
+		Subject: Subject{
+			Country:            s.Country,
+			Organization:       s.Organization,
+			OrganizationalUnit: s.OrganizationalUnit,
+			Locality:           s.Locality,
+			Province:           s.Province,
+			StreetAddress:      s.StreetAddress,
+			PostalCode:         s.PostalCode,
+			SerialNumber:       s.SerialNumber,
+		},
+		CommonName:     s.CommonName,
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		Signer:         signer,
+		CSR:            string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
+	}
+	if cert.Name == "" {
+		cert.Name = s.CommonName
+	}
+	// Definitions execute their subject fields as templates.
+	values := []string{cert.CommonName, cert.Subject.SerialNumber}
+	for _, field := range subjectFields(&cert.Subject) {
+		values = append(values, *field...)
+	}
+	for _, v := range values {
+		if strings.Contains(v, "{{") {
+			return Cert{}, errors.New("subject cannot contain {{")
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		cert.IPAddresses = append(cert.IPAddresses, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		cert.URIs = append(cert.URIs, uri.String())
+	}
+
+	return cert, nil
+}
+
+// requestKey returns the public key of cert's certificate request, checking
+// it is one the certificate could have been generated with.
+func requestKey(cert Cert) (crypto.PublicKey, error) {
+	csr, err := ParseCSR([]byte(cert.CSR))
+	if err != nil {
+		return nil, err
+	}
+	alg, size := PublicKeyParams(csr.PublicKey)
+	if alg == "" {
+		return nil, fmt.Errorf("unsupported certificate request key type %T", csr.PublicKey)
+	}
+	if _, _, err := keyParams(Cert{KeyAlgorithm: alg, KeySize: size}); err != nil {
+		return nil, fmt.Errorf("certificate request key: %v", err)
+	}
+
+	return csr.PublicKey, nil
+}
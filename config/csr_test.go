@@ -0,0 +1,166 @@
+package config_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+)
+
+// newCSR returns a PEM certificate request for commonName and its key.
+func newCSR(t *testing.T, commonName string) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName, Organization: []string{"Acme Inc."}},
+		DNSNames: []string{commonName},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), key
+}
+
+// tampered returns the PEM certificate request data with a signature that
+// does not verify.
+func tampered(t *testing.T, data []byte) []byte {
+	t.Helper()
+	block, _ := pem.Decode(data)
+	der := append([]byte(nil), block.Bytes...)
+	der[len(der)-1] ^= 0xff
+
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+}
+
+func TestParseCSR(t *testing.T) {
+	data, key := newCSR(t, "device.acme.internal")
+	csr, err := config.ParseCSR(data)
+	if err != nil {
+		t.Fatalf("ParseCSR: %v", err)
+	}
+	if !reflect.DeepEqual(csr.PublicKey, key.Public()) {
+		t.Error("ParseCSR returned another key")
+	}
+	block, _ := pem.Decode(data)
+	if _, err := config.ParseCSR(block.Bytes); err != nil {
+		t.Errorf("ParseCSR of DER: %v", err)
+	}
+
+	if _, err := config.ParseCSR(tampered(t, data)); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("ParseCSR of a request with a bad signature = %v; want a signature error", err)
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: block.Bytes})
+	if _, err := config.ParseCSR(cert); err == nil {
+		t.Error("ParseCSR of a certificate succeeded")
+	}
+}
+
+func TestRequestCertTemplate(t *testing.T) {
+	data, _ := newCSR(t, "{{ .Signer.CommonName }}")
+	csr, err := config.ParseCSR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.RequestCert("", "Root CA", csr); err == nil {
+		t.Error("RequestCert accepted a subject template")
+	}
+}
+
+const csrConfig = `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+`
+
+func TestSignCSR(t *testing.T) {
+	c := newConfig(t, csrConfig)
+	results(t, c)
+
+	data, key := newCSR(t, "device.acme.internal")
+	csr, err := config.ParseCSR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := config.RequestCert("", "Root CA", csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.Expire = config.Duration(time.Hour)
+	if err := c.Create(def); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	bundle, err := c.GetBundle("Root CA", "device.acme.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Key != nil {
+		t.Error("the bundle of a request holds a key")
+	}
+	if !reflect.DeepEqual(bundle.Cert.PublicKey, key.Public()) {
+		t.Error("the certificate is not for the key of the request")
+	}
+	if bundle.Cert.Subject.CommonName != "device.acme.internal" || !reflect.DeepEqual(bundle.Cert.DNSNames, []string{"device.acme.internal"}) {
+		t.Errorf("certificate for %v %v; want device.acme.internal", bundle.Cert.Subject, bundle.Cert.DNSNames)
+	}
+}
+
+// A definition carrying a request whose signature does not verify, such as
+// one edited in the configuration, is rejected before anything is signed.
+func TestSignCSRBadSignature(t *testing.T) {
+	c := newConfig(t, csrConfig)
+	results(t, c)
+
+	data, _ := newCSR(t, "device.acme.internal")
+	def := config.Cert{
+		Name:       "device",
+		CommonName: "device.acme.internal",
+		Signer:     "Root CA",
+		Expire:     config.Duration(time.Hour),
+		CSR:        string(tampered(t, data)),
+	}
+	err := c.Create(def)
+	if _, ok := err.(config.ValidationErrors); !ok || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("Create = %v; want a validation error about the signature", err)
+	}
+	if cert, _ := c.Store.Get("device"); cert != nil {
+		t.Error("the definition was added")
+	}
+	if _, err := c.GetBundle("Root CA", "device"); err == nil {
+		t.Error("the certificate was issued")
+	}
+}
+
+func TestSignProfile(t *testing.T) {
+	ca := config.Cert{Name: "Root CA", IsCA: true, SignProfiles: []string{"tls-server", "tls-client"}}
+	tests := []struct {
+		ca        config.Cert
+		requested string
+		want      string
+		ok        bool
+	}{
+		{ca, "", "tls-server", true},
+		{ca, "tls-client", "tls-client", true},
+		{ca, "code-signing", "", false},
+		{config.Cert{Name: "Root CA", IsCA: true}, "", "", true},
+		{config.Cert{Name: "Root CA", IsCA: true}, "tls-server", "", false},
+	}
+	for _, test := range tests {
+		got, err := config.SignProfile(test.ca, test.requested)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("SignProfile(%v, %q) = %q, %v; want %q, ok %v", test.ca.SignProfiles, test.requested, got, err, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that is written as a string such as "720h" in
+// every configuration and storage format.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", string(b), err)
+	}
+	*d = Duration(v)
+
+	return nil
+}
+
+// UnmarshalJSON also accepts a plain number of nanoseconds, the way
+// time.Duration itself is encoded.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var ns int64
+	if err := json.Unmarshal(b, &ns); err == nil {
+		*d = Duration(ns)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %v", string(b))
+	}
+
+	return d.UnmarshalText([]byte(s))
+}
+
+// UnmarshalYAML reports the line of malformed durations, which yaml does not
+// do for errors returned by UnmarshalText.
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if err := d.UnmarshalText([]byte(value.Value)); err != nil {
+		return &lineError{Line: value.Line, Err: err}
+	}
+
+	return nil
+}
+
+// lineError attaches the line it occurred at to a decoding error.
+type lineError struct {
+	Line int
+	Err  error
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *lineError) Unwrap() error {
+	return e.Err
+}
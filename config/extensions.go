@@ -0,0 +1,412 @@
+package config
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policy is a certificate policy the certificate is issued under.
+type Policy struct {
+	OID string `yaml:"oid" json:"oid" toml:"oid"`
+	// CPS lists URIs of the certification practice statement.
+	CPS []string `yaml:"cps,omitempty" json:"cps,omitempty" toml:"cps,omitempty"`
+	// UserNotice is text relying parties may display for the policy.
+	UserNotice string `yaml:"userNotice,omitempty" json:"userNotice,omitempty" toml:"userNotice,omitempty"`
+}
+
+// Extension is an extension added to the certificate as is. The value is
+// either DER in hex, with optional colons between bytes, or text encoded as
+// a UTF8String.
+type Extension struct {
+	OID      string `yaml:"oid" json:"oid" toml:"oid"`
+	Critical bool   `yaml:"critical,omitempty" json:"critical,omitempty" toml:"critical,omitempty"`
+	DER      string `yaml:"der,omitempty" json:"der,omitempty" toml:"der,omitempty"`
+	UTF8     string `yaml:"utf8,omitempty" json:"utf8,omitempty" toml:"utf8,omitempty"`
+}
+
+var (
+	oidCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidQualifierCPS        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidQualifierUserNotice = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// extensionNames names well known extensions.
+var extensionNames = map[string]string{
+	"2.5.29.14":               "subjectKeyIdentifier",
+	"2.5.29.15":               "keyUsage",
+	"2.5.29.17":               "subjectAltName",
+	"2.5.29.19":               "basicConstraints",
+	"2.5.29.30":               "nameConstraints",
+	"2.5.29.31":               "crlDistributionPoints",
+	"2.5.29.32":               "certificatePolicies",
+	"2.5.29.35":               "authorityKeyIdentifier",
+	"2.5.29.37":               "extKeyUsage",
+	"1.3.6.1.5.5.7.1.1":       "authorityInfoAccess",
+	"1.3.6.1.5.5.7.48.1.5":    "ocspNoCheck",
+	"2.16.840.1.113730.1.1":   "netscapeCertType",
+	"1.3.6.1.4.1.11129.2.4.2": "signedCertificateTimestamps",
+}
+
+// derivedExtension reports whether the extension with oid is derived from
+// other fields of the definition.
+func derivedExtension(oid string) bool {
+	switch oid {
+	case "2.5.29.14", "2.5.29.15", "2.5.29.17", "2.5.29.19", "2.5.29.30",
+		"2.5.29.31", "2.5.29.35", "2.5.29.37", "1.3.6.1.5.5.7.1.1":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseOID parses a dotted object identifier.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = n
+	}
+	if oid[0] > 2 || oid[0] < 2 && oid[1] > 39 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+
+	return oid, nil
+}
+
+// policyInformation and policyQualifier are the ASN.1 structures of the
+// certificate policies extension, RFC 5280 section 4.2.1.4.
+type policyInformation struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []policyQualifier `asn1:"optional,omitempty"`
+}
+
+type policyQualifier struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier asn1.RawValue
+}
+
+type userNotice struct {
+	ExplicitText string `asn1:"utf8"`
+}
+
+// policiesExtension encodes the certificate policies extension.
+func policiesExtension(policies []Policy) (pkix.Extension, error) {
+	var infos []policyInformation
+	for _, p := range policies {
+		oid, err := parseOID(p.OID)
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("invalid policy: %v", err)
+		}
+		info := policyInformation{Policy: oid}
+		for _, cps := range p.CPS {
+			if u, err := url.Parse(cps); err != nil || !u.IsAbs() {
+				return pkix.Extension{}, fmt.Errorf("invalid CPS URI %q of policy %v", cps, p.OID)
+			}
+			b, err := asn1.MarshalWithParams(cps, "ia5")
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("invalid CPS URI %q of policy %v: %v", cps, p.OID, err)
+			}
+			info.Qualifiers = append(info.Qualifiers, policyQualifier{ID: oidQualifierCPS, Qualifier: asn1.RawValue{FullBytes: b}})
+		}
+		if p.UserNotice != "" {
+			b, err := asn1.Marshal(userNotice{ExplicitText: p.UserNotice})
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("invalid user notice of policy %v: %v", p.OID, err)
+			}
+			info.Qualifiers = append(info.Qualifiers, policyQualifier{ID: oidQualifierUserNotice, Qualifier: asn1.RawValue{FullBytes: b}})
+		}
+		infos = append(infos, info)
+	}
+
+	value, err := asn1.Marshal(infos)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed encoding certificate policies: %v", err)
+	}
+
+	return pkix.Extension{Id: oidCertificatePolicies, Value: value}, nil
+}
+
+// extension encodes e.
+func (e Extension) extension() (pkix.Extension, error) {
+	oid, err := parseOID(e.OID)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("invalid extension: %v", err)
+	}
+	if derivedExtension(oid.String()) || oid.Equal(oidCertificatePolicies) {
+		return pkix.Extension{}, fmt.Errorf("extension %v (%v) cannot be set directly", oid, extensionNames[oid.String()])
+	}
+
+	var value []byte
+	switch {
+	case e.DER != "" && e.UTF8 != "":
+		return pkix.Extension{}, fmt.Errorf("extension %v has both a der and a utf8 value", e.OID)
+	case e.DER != "":
+		value, err = hex.DecodeString(strings.Replace(e.DER, ":", "", -1))
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("invalid der value of extension %v: %v", e.OID, err)
+		}
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) > 0 {
+			return pkix.Extension{}, fmt.Errorf("der value of extension %v is not a single DER value", e.OID)
+		}
+	case e.UTF8 != "":
+		if value, err = asn1.MarshalWithParams(e.UTF8, "utf8"); err != nil {
+			return pkix.Extension{}, fmt.Errorf("invalid utf8 value of extension %v: %v", e.OID, err)
+		}
+	default:
+		return pkix.Extension{}, fmt.Errorf("extension %v has no value", e.OID)
+	}
+
+	return pkix.Extension{Id: oid, Critical: e.Critical, Value: value}, nil
+}
+
+// extraExtensions encodes the policies and custom extensions of cert.
+func extraExtensions(cert Cert) ([]pkix.Extension, error) {
+	var exts []pkix.Extension
+	if len(cert.Policies) > 0 {
+		ext, err := policiesExtension(cert.Policies)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, ext)
+	}
+
+	seen := map[string]bool{}
+	for _, e := range cert.Extensions {
+		ext, err := e.extension()
+		if err != nil {
+			return nil, err
+		}
+		if seen[ext.Id.String()] {
+			return nil, fmt.Errorf("duplicate extension %v", ext.Id)
+		}
+		seen[ext.Id.String()] = true
+		exts = append(exts, ext)
+	}
+
+	return exts, nil
+}
+
+// sameExtensions reports whether the extensions of issued not derived from
+// other fields are the policies and custom extensions of cert.
+func sameExtensions(issued *x509.Certificate, cert Cert) bool {
+	want, err := extraExtensions(cert)
+	if err != nil {
+		return false
+	}
+
+	var have []pkix.Extension
+	for _, ext := range issued.Extensions {
+		if !derivedExtension(ext.Id.String()) {
+			have = append(have, ext)
+		}
+	}
+	if len(have) != len(want) {
+		return false
+	}
+	sortExtensions(have)
+	sortExtensions(want)
+	for i := range have {
+		if !have[i].Id.Equal(want[i].Id) || have[i].Critical != want[i].Critical || !bytes.Equal(have[i].Value, want[i].Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortExtensions(exts []pkix.Extension) {
+	sort.Slice(exts, func(i, j int) bool {
+		return exts[i].Id.String() < exts[j].Id.String()
+	})
+}
+
+// DecodedExtension is an extension of an issued certificate in readable
+// form. Value holds the decoded fields of known extensions, and the value of
+// others keyed utf8 or der, as in the configuration.
+type DecodedExtension struct {
+	OID      string      `json:"oid"`
+	Name     string      `json:"name,omitempty"`
+	Critical bool        `json:"critical"`
+	Value    interface{} `json:"value"`
+}
+
+// DecodeExtensions returns the extensions of cert in readable form.
+func DecodeExtensions(cert *x509.Certificate) []DecodedExtension {
+	var exts []DecodedExtension
+	for _, ext := range cert.Extensions {
+		oid := ext.Id.String()
+		d := DecodedExtension{OID: oid, Name: extensionNames[oid], Critical: ext.Critical}
+		switch oid {
+		case "2.5.29.14":
+			d.Value = hex.EncodeToString(cert.SubjectKeyId)
+		case "2.5.29.35":
+			d.Value = hex.EncodeToString(cert.AuthorityKeyId)
+		case "2.5.29.15":
+			d.Value = keyUsageNames(cert.KeyUsage)
+		case "2.5.29.37":
+			d.Value = extKeyUsageNames(cert.ExtKeyUsage)
+		case "2.5.29.19":
+			bc := map[string]interface{}{"isCA": cert.IsCA}
+			if cert.IsCA && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+				bc["maxPathLen"] = cert.MaxPathLen
+			}
+			d.Value = bc
+		case "2.5.29.17":
+			san := map[string]interface{}{}
+			if len(cert.DNSNames) > 0 {
+				san["dnsNames"] = cert.DNSNames
+			}
+			if len(cert.EmailAddresses) > 0 {
+				san["emailAddresses"] = cert.EmailAddresses
+			}
+			if len(cert.IPAddresses) > 0 {
+				san["ipAddresses"] = ipStrings(cert.IPAddresses)
+			}
+			if len(cert.URIs) > 0 {
+				san["uris"] = uriStrings(cert.URIs)
+			}
+			d.Value = san
+		case "2.5.29.30":
+			d.Value = map[string]interface{}{
+				"permitted": constraintNames(cert.PermittedDNSDomains, cert.PermittedEmailAddresses, cert.PermittedIPRanges, cert.PermittedURIDomains),
+				"excluded":  constraintNames(cert.ExcludedDNSDomains, cert.ExcludedEmailAddresses, cert.ExcludedIPRanges, cert.ExcludedURIDomains),
+			}
+		case "2.5.29.31":
+			d.Value = cert.CRLDistributionPoints
+		case "1.3.6.1.5.5.7.1.1":
+			d.Value = map[string]interface{}{"ocsp": cert.OCSPServer, "issuers": cert.IssuingCertificateURL}
+		case "2.5.29.32":
+			if policies, err := decodePolicies(ext.Value); err == nil {
+				d.Value = policies
+			} else {
+				d.Value = decodeCustom(ext.Value)
+			}
+		default:
+			d.Value = decodeCustom(ext.Value)
+		}
+		exts = append(exts, d)
+	}
+
+	return exts
+}
+
+func keyUsageNames(usage x509.KeyUsage) []string {
+	names := []string{}
+	for name, u := range keyUsages {
+		if usage&u != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func extKeyUsageNames(usages []x509.ExtKeyUsage) []string {
+	names := []string{}
+	for _, u := range usages {
+		for name, known := range extKeyUsages {
+			if known == u {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+func constraintNames(dns, email []string, ips []*net.IPNet, uri []string) NameSet {
+	set := NameSet{DNSDomains: dns, EmailAddresses: email, URIDomains: uri}
+	for _, r := range ips {
+		set.IPRanges = append(set.IPRanges, r.String())
+	}
+
+	return set
+}
+
+// decodePolicies decodes the certificate policies extension.
+func decodePolicies(value []byte) ([]Policy, error) {
+	var infos []policyInformation
+	if rest, err := asn1.Unmarshal(value, &infos); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after certificate policies")
+	}
+
+	var policies []Policy
+	for _, info := range infos {
+		p := Policy{OID: info.Policy.String()}
+		for _, q := range info.Qualifiers {
+			switch {
+			case q.ID.Equal(oidQualifierCPS):
+				var cps string
+				if _, err := asn1.Unmarshal(q.Qualifier.FullBytes, &cps); err != nil {
+					return nil, err
+				}
+				p.CPS = append(p.CPS, cps)
+			case q.ID.Equal(oidQualifierUserNotice):
+				// The notice is a sequence of an optional reference,
+				// itself a sequence, and the optional explicit text.
+				var notice asn1.RawValue
+				if _, err := asn1.Unmarshal(q.Qualifier.FullBytes, &notice); err != nil {
+					return nil, err
+				}
+				for rest := notice.Bytes; len(rest) > 0; {
+					var field asn1.RawValue
+					var err error
+					if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+						return nil, err
+					}
+					if field.Tag != asn1.TagSequence {
+						p.UserNotice = string(field.Bytes)
+					}
+				}
+			}
+		}
+		policies = append(policies, p)
+	}
+
+	return policies, nil
+}
+
+// decodeCustom decodes the value of an extension holding a string, or
+// returns its DER when it holds something else.
+func decodeCustom(value []byte) map[string]string {
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(value, &raw); err == nil && len(rest) == 0 && raw.Class == asn1.ClassUniversal {
+		switch raw.Tag {
+		case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String:
+			return map[string]string{"utf8": string(raw.Bytes)}
+		}
+	}
+
+	return map[string]string{"der": derHex(value)}
+}
+
+// derHex formats DER as hex bytes separated by colons.
+func derHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02x", c)
+	}
+
+	return strings.Join(parts, ":")
+}
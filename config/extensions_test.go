@@ -0,0 +1,165 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoliciesExtension(t *testing.T) {
+	tests := []struct {
+		policies []Policy
+		// want is the DER of the extension value in hex.
+		want string
+	}{
+		{[]Policy{{OID: "1.2.3"}}, "3006" + "3004" + "06022a03"},
+		{
+			[]Policy{{OID: "1.2.3", CPS: []string{"http://a/"}}},
+			"301f" + "301d" + "06022a03" + "3017" + "3015" + "06082b06010505070201" + "1609" + hex.EncodeToString([]byte("http://a/")),
+		},
+		{
+			[]Policy{{OID: "2.23.140.1.2.1"}, {OID: "1.2.3", UserNotice: "hi"}},
+			"3024" + "3008" + "060667810c010201" + "3018" + "06022a03" + "3012" + "3010" + "06082b06010505070202" + "3004" + "0c026869",
+		},
+	}
+	for _, test := range tests {
+		ext, err := policiesExtension(test.policies)
+		if err != nil {
+			t.Errorf("policiesExtension(%+v) failed: %v", test.policies, err)
+			continue
+		}
+		if !ext.Id.Equal(oidCertificatePolicies) || ext.Critical {
+			t.Errorf("policiesExtension(%+v) = %v critical %v, want a non critical %v", test.policies, ext.Id, ext.Critical, oidCertificatePolicies)
+		}
+		if got := hex.EncodeToString(ext.Value); got != test.want {
+			t.Errorf("policiesExtension(%+v) = %v, want %v", test.policies, got, test.want)
+		}
+		if got, err := decodePolicies(ext.Value); err != nil || !reflect.DeepEqual(got, test.policies) {
+			t.Errorf("decodePolicies(policiesExtension(%+v)) = %+v, %v", test.policies, got, err)
+		}
+	}
+
+	for _, p := range []Policy{{OID: "1"}, {OID: "1.40"}, {OID: "3.1"}, {OID: "1.2.x"}, {OID: "1.2.3", CPS: []string{"cps.html"}}} {
+		if _, err := policiesExtension([]Policy{p}); err == nil {
+			t.Errorf("policiesExtension(%+v) succeeded, want an error", p)
+		}
+	}
+}
+
+func TestExtension(t *testing.T) {
+	tests := []struct {
+		ext Extension
+		// want is the DER of the value in hex, err part of the expected
+		// error.
+		want, err string
+	}{
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", UTF8: "hello"}, want: "0c0568656c6c6f"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", DER: "30:03:02:01:05", Critical: true}, want: "3003020105"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", DER: "0500"}, want: "0500"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", DER: "05:00", UTF8: "null"}, err: "both"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1"}, err: "no value"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", DER: "zz"}, err: "invalid der value"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", DER: "05:00:05:00"}, err: "not a single DER value"},
+		{ext: Extension{OID: "1.3.6.1.4.1.99999.1", DER: "30:05:02:01"}, err: "not a single DER value"},
+		{ext: Extension{OID: "2.5.29.17", UTF8: "web"}, err: "subjectAltName"},
+		{ext: Extension{OID: "2.5.29.32", DER: "05:00"}, err: "certificatePolicies"},
+		{ext: Extension{OID: "1.2.-3", UTF8: "web"}, err: "invalid OID"},
+	}
+	for _, test := range tests {
+		ext, err := test.ext.extension()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%+v.extension() = %v, want an error containing %q", test.ext, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v.extension() failed: %v", test.ext, err)
+			continue
+		}
+		if ext.Id.String() != test.ext.OID || ext.Critical != test.ext.Critical || hex.EncodeToString(ext.Value) != test.want {
+			t.Errorf("%+v.extension() = %v %v %x, want %v %v %v", test.ext, ext.Id, ext.Critical, ext.Value, test.ext.OID, test.ext.Critical, test.want)
+		}
+	}
+
+	dup := Cert{Extensions: []Extension{{OID: "1.2.3", UTF8: "a"}, {OID: "1.2.3", UTF8: "b"}}}
+	if _, err := extraExtensions(dup); err == nil || !strings.Contains(err.Error(), "duplicate extension") {
+		t.Errorf("extraExtensions() with a duplicate = %v, want an error", err)
+	}
+}
+
+// Certificates issued with the extensions carry them as parsed by the
+// standard library, and decode back to the definition.
+func TestIssuedExtensions(t *testing.T) {
+	cert := Cert{
+		Policies: []Policy{{OID: "1.2.3", CPS: []string{"https://example.com/cps"}, UserNotice: "Test only"}},
+		Extensions: []Extension{
+			{OID: "1.3.6.1.4.1.99999.1", UTF8: "hello"},
+			{OID: "1.3.6.1.4.1.99999.2", DER: "30:03:02:01:05", Critical: true},
+		},
+	}
+	exts, err := extraExtensions(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: exts,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The critical extension is unknown to the standard library, which
+	// refuses to verify but still parses the certificate.
+	issued, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []asn1.ObjectIdentifier{{1, 2, 3}}; !reflect.DeepEqual(issued.PolicyIdentifiers, want) {
+		t.Errorf("PolicyIdentifiers = %v, want %v", issued.PolicyIdentifiers, want)
+	}
+	decoded := map[string]interface{}{}
+	for _, d := range DecodeExtensions(issued) {
+		decoded[d.OID] = d.Value
+	}
+	want := map[string]interface{}{
+		"2.5.29.32":           cert.Policies,
+		"1.3.6.1.4.1.99999.1": map[string]string{"utf8": "hello"},
+		"1.3.6.1.4.1.99999.2": map[string]string{"der": "30:03:02:01:05"},
+	}
+	for oid, value := range want {
+		if !reflect.DeepEqual(decoded[oid], value) {
+			t.Errorf("decoded extension %v = %#v, want %#v", oid, decoded[oid], value)
+		}
+	}
+
+	if !sameExtensions(issued, cert) {
+		t.Error("sameExtensions() = false for the definition the certificate was issued with")
+	}
+	changed := cert
+	changed.Extensions = []Extension{cert.Extensions[0], {OID: "1.3.6.1.4.1.99999.2", DER: "30:03:02:01:05"}}
+	if sameExtensions(issued, changed) {
+		t.Error("sameExtensions() = true with an extension no longer critical")
+	}
+	changed = cert
+	changed.Policies = nil
+	if sameExtensions(issued, changed) {
+		t.Error("sameExtensions() = true without the policies")
+	}
+}
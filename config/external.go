@@ -0,0 +1,259 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExternalStore is implemented by easypki stores able to hold the requests
+// of externally signed CAs until their certificate is imported, and the
+// chains they were imported with. Externally signed CAs can only be planned
+// for such stores.
+type ExternalStore interface {
+	// PutRequest stores the DER key and PKCS #10 request generated for the
+	// named CA, replacing any pending one.
+	PutRequest(name string, key, csr []byte) error
+	// Request returns the pending key and request of the named CA, both nil
+	// if there is none.
+	Request(name string) (key, csr []byte, err error)
+	DeleteRequest(name string) error
+	// PutChain stores the certificates above the named CA, nearest first, as
+	// concatenated DER.
+	PutChain(name string, chain []byte) error
+	Chain(name string) ([]byte, error)
+}
+
+// ErrNoRequest is returned when importing a certificate for a CA that has no
+// pending request.
+var ErrNoRequest = errors.New("no certificate request is pending")
+
+func (c *Config) externalStore() (ExternalStore, error) {
+	s, ok := c.EasyPKI.Store.(ExternalStore)
+	if !ok {
+		return nil, errors.New("the easypki store cannot hold certificate requests")
+	}
+
+	return s, nil
+}
+
+// Request returns the request pending for the externally signed CA name, or
+// nil if there is none.
+func (c *Config) Request(name string) (*x509.CertificateRequest, error) {
+	s, err := c.externalStore()
+	if err != nil {
+		return nil, err
+	}
+	_, raw, err := s.Request(name)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing certificate request of %v: %v", name, err)
+	}
+
+	return csr, nil
+}
+
+// requestCert generates a new key for the externally signed CA cert and a
+// request for it, replacing any pending one. The request asks for the
+// extensions the certificate would be issued with, which are encoded by
+// having the key sign the certificate itself first.
+func (c *Config) requestCert(cert Cert, p Profile) error {
+	s, err := c.externalStore()
+	if err != nil {
+		return err
+	}
+
+	key, err := generateKey(cert)
+	if err != nil {
+		return fmt.Errorf("failed generating private key for %v: %v", cert.Name, err)
+	}
+	tmpl, err := certTemplate(cert, p, key.Public())
+	if err != nil {
+		return fmt.Errorf("cannot create request for %v: %v", cert.Name, err)
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("failed encoding extensions of %v: %v", cert.Name, err)
+	}
+	self, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return fmt.Errorf("failed encoding extensions of %v: %v", cert.Name, err)
+	}
+	var exts []pkix.Extension
+	for _, ext := range self.Extensions {
+		// The external CA identifies itself.
+		if ext.Id.String() != "2.5.29.35" {
+			exts = append(exts, ext)
+		}
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:         tmpl.Subject,
+		ExtraExtensions: exts,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed creating certificate request for %v: %v", cert.Name, err)
+	}
+	rawKey, err := MarshalPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("cannot create request for %v: %v", cert.Name, err)
+	}
+	if err := s.PutRequest(cert.Name, rawKey, csr); err != nil {
+		return fmt.Errorf("failed saving certificate request for %v: %v", cert.Name, err)
+	}
+
+	return nil
+}
+
+// Import stores the certificate an external CA issued for the externally
+// signed CA name, and chain, the certificates above it, nearest first. The
+// certificate must be for the key of the pending request or, to import a
+// renewal, for the key of the certificate in use. Certificates below the CA
+// are only issued on the next reconciliation.
+func (c *Config) Import(name string, cert *x509.Certificate, chain []*x509.Certificate) error {
+	c.issuing.Lock()
+	defer c.issuing.Unlock()
+	defer c.resetOCSP()
+
+	def, err := c.Store.Get(name)
+	if err != nil {
+		return err
+	}
+	if def == nil {
+		return ErrNotFound
+	}
+	if !def.External {
+		return fmt.Errorf("%v is not an externally signed CA", name)
+	}
+	s, err := c.externalStore()
+	if err != nil {
+		return err
+	}
+
+	rawKey, _, err := s.Request(name)
+	if err != nil {
+		return fmt.Errorf("failed fetching certificate request of %v: %v", name, err)
+	}
+	pending := rawKey != nil
+	if !pending {
+		if rawKey, _, err = c.EasyPKI.Store.Fetch(name, name); err != nil || rawKey == nil {
+			return ErrNoRequest
+		}
+	}
+	key, err := ParsePrivateKey(rawKey)
+	if err != nil {
+		return fmt.Errorf("failed parsing key of %v: %v", name, err)
+	}
+
+	if !samePublicKey(cert.PublicKey, key.Public()) {
+		return errors.New("certificate is not for the requested key")
+	}
+	if !cert.IsCA || !cert.BasicConstraintsValid {
+		return errors.New("certificate is not a CA")
+	}
+	var rawChain []byte
+	signed := cert
+	for _, ca := range chain {
+		if err := signed.CheckSignatureFrom(ca); err != nil {
+			return fmt.Errorf("%v is not signed by %v: %v", signed.Subject.CommonName, ca.Subject.CommonName, err)
+		}
+		rawChain = append(rawChain, ca.Raw...)
+		signed = ca
+	}
+
+	if err := c.EasyPKI.Store.Add(name, name, true, rawKey, cert.Raw); err != nil {
+		return fmt.Errorf("failed saving bundle for %v: %v", name, err)
+	}
+	if err := s.PutChain(name, rawChain); err != nil {
+		return fmt.Errorf("failed saving chain of %v: %v", name, err)
+	}
+	if pending {
+		if err := s.DeleteRequest(name); err != nil {
+			return fmt.Errorf("failed deleting certificate request of %v: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// ExternalChain returns the certificates above the externally signed CA
+// name as they were imported, nearest first, or nil if name is not one.
+func (c *Config) ExternalChain(name string) ([]*x509.Certificate, error) {
+	s, ok := c.EasyPKI.Store.(ExternalStore)
+	if !ok {
+		return nil, nil
+	}
+	raw, err := s.Chain(name)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificates(raw)
+}
+
+// planExternal plans the externally signed CA cert. A request is generated
+// when none was issued yet or the issued certificate no longer matches the
+// definition; the CA then waits for its certificate to be imported.
+func (c *Config) planExternal(step *Step, cert Cert) {
+	csr, err := c.Request(cert.Name)
+	if err != nil {
+		step.Action = Failed
+		step.Error = err.Error()
+		return
+	}
+	existing := c.issuedCert(cert)
+	step.awaiting = existing == nil
+
+	switch {
+	case csr != nil:
+		step.Action = Pending
+		if step.Changes = requestChanges(cert, csr); len(step.Changes) > 0 {
+			step.Action = Requested
+		}
+	case existing == nil:
+		step.Action = Requested
+	default:
+		if step.Changes = externalChanges(cert, existing); len(step.Changes) > 0 {
+			step.Action = Requested
+		}
+	}
+}
+
+// requestChanges compares the definition of an externally signed CA with its
+// pending request and returns the names of the fields that differ.
+func requestChanges(cert Cert, csr *x509.CertificateRequest) []string {
+	var changes []string
+	if csr.Subject.String() != cert.Subject.Name(cert.CommonName).String() {
+		changes = append(changes, "subject")
+	}
+	alg, size, _ := keyParams(cert)
+	if reqAlg, reqSize := PublicKeyParams(csr.PublicKey); reqAlg != alg || reqSize != size {
+		changes = append(changes, "key")
+	}
+
+	return changes
+}
+
+// externalChanges compares the definition of an externally signed CA with
+// its imported certificate. Everything else is up to the external CA.
+func externalChanges(cert Cert, issued *x509.Certificate) []string {
+	var changes []string
+	if issued.Subject.String() != cert.Subject.Name(cert.CommonName).String() {
+		changes = append(changes, "subject")
+	}
+	alg, size, _ := keyParams(cert)
+	if issuedAlg, issuedSize := PublicKeyParams(issued.PublicKey); issuedAlg != alg || issuedSize != size {
+		changes = append(changes, "key")
+	}
+	if time.Now().After(issued.NotAfter) {
+		changes = append(changes, "expired")
+	}
+
+	return changes
+}
@@ -0,0 +1,165 @@
+package config_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+)
+
+const externalConfig = `certs:
+  - name: Issuing CA
+    commonName: Issuing CA
+    isCA: true
+    external: true
+    signer: Offline Root
+    expire: 8760h
+  - name: web
+    commonName: web.example.com
+    signer: Issuing CA
+    dnsNames: [web.example.com]
+    expire: 720h
+`
+
+// offlineRoot returns a root CA kept outside of the configuration.
+func offlineRoot(t *testing.T, commonName string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(2 * 8760 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	return signWith(t, tmpl, key.Public(), tmpl, key), key
+}
+
+func signWith(t *testing.T, tmpl *x509.Certificate, pub crypto.PublicKey, parent *x509.Certificate, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+// signRequest issues the certificate csr asks for, with the extensions it
+// requests, as an external CA would.
+func signRequest(t *testing.T, csr *x509.CertificateRequest, ca *x509.Certificate, key crypto.Signer) *x509.Certificate {
+	t.Helper()
+	return signWith(t, &x509.Certificate{
+		SerialNumber:    big.NewInt(time.Now().UnixNano()),
+		Subject:         csr.Subject,
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(8760 * time.Hour),
+		ExtraExtensions: csr.Extensions,
+	}, csr.PublicKey, ca, key)
+}
+
+func TestExternal(t *testing.T) {
+	c := newConfig(t, externalConfig)
+	res := results(t, c)
+	if got := res["Issuing CA"].Action; got != config.Requested {
+		t.Errorf("Issuing CA action = %v, want %v", got, config.Requested)
+	}
+	if got := res["web"].Action; got != config.Pending {
+		t.Errorf("web action = %v, want %v", got, config.Pending)
+	}
+
+	csr, err := c.Request("Issuing CA")
+	if err != nil || csr == nil {
+		t.Fatalf("Request() = %v, %v", csr, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("request signature: %v", err)
+	}
+	if csr.Subject.CommonName != "Issuing CA" {
+		t.Errorf("request common name = %q, want Issuing CA", csr.Subject.CommonName)
+	}
+	root, rootKey := offlineRoot(t, "Offline Root")
+	issued := signRequest(t, csr, root, rootKey)
+	if !issued.IsCA {
+		t.Fatal("the request does not ask for a CA certificate")
+	}
+
+	other, _ := offlineRoot(t, "Other Root")
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		cert  *x509.Certificate
+		chain []*x509.Certificate
+		err   string
+	}{
+		{"Issuing CA", issued, []*x509.Certificate{other}, "Issuing CA is not signed by Other Root"},
+		{"Issuing CA", issued, []*x509.Certificate{root, other}, "Offline Root is not signed by Other Root"},
+		{"Issuing CA", signRequest(t, &x509.CertificateRequest{Subject: csr.Subject, Extensions: csr.Extensions, PublicKey: otherKey.Public()}, root, rootKey),
+			[]*x509.Certificate{root}, "not for the requested key"},
+		{"Issuing CA", signWith(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: csr.Subject, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}, csr.PublicKey, root, rootKey),
+			[]*x509.Certificate{root}, "not a CA"},
+		{"web", issued, []*x509.Certificate{root}, "not an externally signed CA"},
+	} {
+		if err := c.Import(test.name, test.cert, test.chain); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Import(%v, %v) = %v, want an error containing %q", test.name, test.cert.Subject.CommonName, err, test.err)
+		}
+	}
+	if csr, err := c.Request("Issuing CA"); err != nil || csr == nil {
+		t.Fatalf("Request() after rejected imports = %v, %v; want the request still pending", csr, err)
+	}
+
+	if err := c.Import("Issuing CA", issued, []*x509.Certificate{root}); err != nil {
+		t.Fatalf("Import() failed: %v", err)
+	}
+	if csr, err := c.Request("Issuing CA"); err != nil || csr != nil {
+		t.Errorf("Request() after the import = %v, %v; want none", csr, err)
+	}
+	if chain, err := c.ExternalChain("Issuing CA"); err != nil || len(chain) != 1 || !chain[0].Equal(root) {
+		t.Errorf("ExternalChain() = %v, %v; want the offline root", chain, err)
+	}
+
+	res = results(t, c)
+	if got := res["Issuing CA"].Action; got != config.Unchanged {
+		t.Errorf("Issuing CA action after the import = %v, want %v", got, config.Unchanged)
+	}
+	if got := res["web"].Action; got != config.Created {
+		t.Errorf("web action after the import = %v, want %v", got, config.Created)
+	}
+	web, err := c.GetBundle("Issuing CA", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates.AddCert(issued)
+	if _, err := web.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "web.example.com"}); err != nil {
+		t.Errorf("web does not verify up to the offline root: %v", err)
+	}
+
+	// A renewal is for the key in use.
+	renewed := signRequest(t, csr, root, rootKey)
+	if err := c.Import("Issuing CA", renewed, []*x509.Certificate{root}); err != nil {
+		t.Errorf("Import() of a renewal failed: %v", err)
+	}
+}
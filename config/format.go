@@ -0,0 +1,232 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file formats.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// fileFormat picks the format of a configuration file by its extension.
+// Files without a known extension are YAML.
+func fileFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// configExtension reports whether name has the extension of a configuration
+// file, which is what makes it a fragment of a configuration directory.
+func configExtension(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml", ".json", ".toml":
+		return true
+	default:
+		return false
+	}
+}
+
+// fragment is what a single configuration file defines, in any format.
+type fragment struct {
+	path     string
+	certs    []entry
+	profiles []entry
+	vars     []variable
+	// include holds the include globs and includeLine where they are.
+	include     []string
+	includeLine int
+	errs        ValidationErrors
+}
+
+// entry is a certificate or profile definition. Entries are decoded once
+// every file is read, as they may refer to variables of any of them.
+type entry struct {
+	name string
+	line int
+	// decode expands the variable references of the entry and decodes it
+	// into v.
+	decode func(vars *variables, v interface{}) error
+}
+
+// fail records an entry that could not be decoded. Errors carrying their own
+// line replace the entry's.
+func (f *fragment) fail(name string, line int, err error) {
+	src := Source{File: f.path, Line: line}
+	var le *lineError
+	if errors.As(err, &le) {
+		src.Line = le.Line
+		err = le.Err
+	}
+	f.errs = append(f.errs, ValidationError{Name: name, Source: src, Msg: err.Error()})
+}
+
+// parseFragment parses the configuration file at path in the given format.
+func parseFragment(path, format string) (*fragment, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading configuration file %v: %v", path, err)
+	}
+
+	f := &fragment{path: path}
+	switch format {
+	case FormatYAML:
+		err = parseYAML(f, b)
+	case FormatJSON:
+		err = parseJSON(f, b)
+	case FormatTOML:
+		err = parseTOML(f, b)
+	default:
+		err = fmt.Errorf("unknown configuration format %q for %v", format, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// certList is the certs list of a configuration file being edited.
+type certList interface {
+	// index returns the position of the named entry, or -1.
+	index(name string) (int, error)
+	add(cert Cert) error
+	replace(i int, cert Cert) error
+	// remove drops the entries with the given names.
+	remove(names map[string]bool) error
+}
+
+// editCerts applies fn to the certs list of the file at path, which need not
+// exist yet, and writes the file back.
+func editCerts(path, format string, fn func(certs certList) error) error {
+	switch format {
+	case FormatYAML:
+		return editFile(path, func(doc *yaml.Node) error {
+			certs, err := certsNode(doc)
+			if err != nil {
+				return err
+			}
+			return fn(&yamlCertList{doc: doc, certs: certs})
+		})
+	case FormatJSON, FormatTOML:
+		return editDocument(path, format, func(doc *document) error {
+			return fn(&documentCertList{doc: doc})
+		})
+	default:
+		return fmt.Errorf("unknown configuration format %q for %v", format, path)
+	}
+}
+
+// document is a configuration file in a format the store rewrites as a whole
+// rather than editing its syntax tree. Comments and formatting are not kept.
+// Entries are Cert and Profile values, or the entries as read when they
+// cannot be decoded before expanding their variables.
+type document struct {
+	Schema   string                 `json:"$schema,omitempty" toml:"-"`
+	Include  interface{}            `json:"include,omitempty" toml:"include,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty" toml:"vars,omitempty"`
+	Profiles map[string]interface{} `json:"profiles,omitempty" toml:"profiles,omitempty"`
+	Certs    []interface{}          `json:"certs" toml:"certs"`
+}
+
+func editDocument(path, format string, fn func(doc *document) error) error {
+	mode := os.FileMode(0644)
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed reading configuration file %v: %v", path, err)
+	}
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	}
+
+	doc := &document{}
+	decode, encode := decodeJSONDocument, encodeJSONDocument
+	if format == FormatTOML {
+		decode, encode = decodeTOMLDocument, encodeTOMLDocument
+	}
+	if len(b) > 0 {
+		if err := decode(b, doc); err != nil {
+			return fmt.Errorf("failed unmarshaling %v config (%v): %v", format, path, err)
+		}
+	}
+
+	if err := fn(doc); err != nil {
+		return err
+	}
+
+	out, err := encode(doc)
+	if err != nil {
+		return fmt.Errorf("failed marshaling %v config (%v): %v", format, path, err)
+	}
+
+	return writeFileAtomic(path, out, mode)
+}
+
+type documentCertList struct {
+	doc *document
+}
+
+func (l *documentCertList) index(name string) (int, error) {
+	for i, item := range l.doc.Certs {
+		if entryName(item) == name {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// entryName returns the name of a certificate of a document.
+func entryName(item interface{}) string {
+	switch item := item.(type) {
+	case Cert:
+		return item.Name
+	case json.RawMessage:
+		var named struct{ Name string }
+		json.Unmarshal(item, &named)
+		return named.Name
+	case map[string]interface{}:
+		name, _ := item["name"].(string)
+		return name
+	default:
+		return ""
+	}
+}
+
+func (l *documentCertList) add(cert Cert) error {
+	l.doc.Certs = append(l.doc.Certs, cert)
+	return nil
+}
+
+func (l *documentCertList) replace(i int, cert Cert) error {
+	l.doc.Certs[i] = cert
+	return nil
+}
+
+func (l *documentCertList) remove(names map[string]bool) error {
+	var kept []interface{}
+	for _, item := range l.doc.Certs {
+		if !names[entryName(item)] {
+			kept = append(kept, item)
+		}
+	}
+	l.doc.Certs = kept
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePEM reads the certificates and the private key in PEM data, in any
+// order. The key may be in PKCS #1, PKCS #8 or SEC 1 form; encrypted keys
+// are not supported. Data without any PEM block is read as a single DER
+// certificate.
+func ParsePEM(data []byte) ([]*x509.Certificate, crypto.Signer, error) {
+	var certs []*x509.Certificate
+	var key crypto.Signer
+	found := false
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		found = true
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed parsing certificate: %v", err)
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			if _, ok := block.Headers["DEK-Info"]; ok {
+				return nil, nil, errors.New("encrypted private keys are not supported")
+			}
+			if key != nil {
+				return nil, nil, errors.New("more than one private key given")
+			}
+			var err error
+			if key, err = ParsePrivateKey(block.Bytes); err != nil {
+				return nil, nil, err
+			}
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, nil, errors.New("encrypted private keys are not supported")
+		}
+	}
+
+	if !found {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("no PEM data found and not a DER certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, nil, errors.New("no certificate found")
+	}
+
+	return certs, key, nil
+}
+
+// ImportCA stores a CA certificate and key generated elsewhere as the bundle
+// of the imported CA name. The certificate must be for key and, unless its
+// signer is outside of the PKI, signed by its signer's certificate.
+// Re-importing replaces the bundle, after which the certificates below it
+// get re-issued.
+func (c *Config) ImportCA(name string, cert *x509.Certificate, key crypto.Signer) error {
+	c.issuing.Lock()
+	defer c.issuing.Unlock()
+	defer c.resetOCSP()
+
+	def, err := c.Store.Get(name)
+	if err != nil {
+		return err
+	}
+	if def == nil {
+		return ErrNotFound
+	}
+	if !def.Imported {
+		return fmt.Errorf("%v is not an imported CA", name)
+	}
+	if !samePublicKey(cert.PublicKey, key.Public()) {
+		return errors.New("private key does not match the certificate")
+	}
+	if !cert.IsCA || !cert.BasicConstraintsValid {
+		return errors.New("certificate is not a CA")
+	}
+
+	caName := name
+	if selfSigned(*def) {
+		if err := cert.CheckSignatureFrom(cert); err != nil {
+			return fmt.Errorf("certificate is not self signed: %v", err)
+		}
+	} else if signerDef, err := c.Store.Get(def.Signer); err != nil {
+		return err
+	} else if signerDef != nil {
+		signer, err := c.GetCA(def.Signer)
+		if err != nil {
+			return fmt.Errorf("cannot check signature of %v: %v", name, err)
+		}
+		if err := cert.CheckSignatureFrom(signer.Cert); err != nil {
+			return fmt.Errorf("certificate is not signed by %v: %v", def.Signer, err)
+		}
+		caName = def.Signer
+	}
+
+	rawKey, err := MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if caName != name {
+		signer, err := c.GetCA(caName)
+		if err != nil {
+			return fmt.Errorf("cannot replace %v: %v", name, err)
+		}
+		if err := c.supersede(caName, name, signer.Cert); err != nil {
+			return err
+		}
+	}
+	if err := c.EasyPKI.Store.Add(caName, name, true, rawKey, cert.Raw); err != nil {
+		return fmt.Errorf("failed saving bundle for %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// planImported plans the imported CA cert, which is never issued here; it
+// and everything below it wait until its bundle is imported.
+func (c *Config) planImported(step *Step, cert Cert) {
+	if c.issuedCert(cert) == nil {
+		step.Action = Pending
+		step.awaiting = true
+	}
+}
+
+// samePublicKey reports whether a and b are the same public key.
+func samePublicKey(a, b crypto.PublicKey) bool {
+	derA, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	derB, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(derA, derB)
+}
@@ -0,0 +1,130 @@
+package config_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+)
+
+const importConfig = `certs:
+  - name: Legacy Root
+    commonName: Legacy Root
+    isCA: true
+    imported: true
+  - name: Legacy Intermediate
+    commonName: Legacy Intermediate
+    signer: Legacy Root
+    isCA: true
+    imported: true
+  - name: Partner CA
+    commonName: Partner CA
+    signer: Partner Root
+    isCA: true
+    imported: true
+  - name: web
+    commonName: web.example.com
+    signer: Legacy Intermediate
+    dnsNames: [web.example.com]
+    expire: 720h
+`
+
+// intermediate returns a CA certificate signed by parent, and its key.
+func intermediate(t *testing.T, commonName string, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signWith(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(8760 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, key.Public(), parent, parentKey), key
+}
+
+func TestImportCA(t *testing.T) {
+	c := newConfig(t, importConfig)
+	res := results(t, c)
+	for _, name := range []string{"Legacy Root", "Legacy Intermediate", "Partner CA", "web"} {
+		if got := res[name].Action; got != config.Pending {
+			t.Errorf("%v action = %v, want %v", name, got, config.Pending)
+		}
+	}
+
+	root, rootKey := offlineRoot(t, "Legacy Root")
+	mid, midKey := intermediate(t, "Legacy Intermediate", root, rootKey)
+	other, otherKey := offlineRoot(t, "Other Root")
+	forged, forgedKey := intermediate(t, "Legacy Intermediate", other, otherKey)
+	crossed, crossedKey := intermediate(t, "Legacy Root", other, otherKey)
+	leaf := signWith(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Legacy Root"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, rootKey.Public(), root, rootKey)
+
+	for _, test := range []struct {
+		name string
+		cert *x509.Certificate
+		key  crypto.Signer
+		err  string
+	}{
+		{"Legacy Intermediate", mid, midKey, "cannot check signature of Legacy Intermediate"},
+		{"Legacy Root", root, otherKey, "private key does not match the certificate"},
+		{"Legacy Root", leaf, rootKey, "certificate is not a CA"},
+		{"Legacy Root", crossed, crossedKey, "certificate is not self signed"},
+		{"web", root, rootKey, "web is not an imported CA"},
+	} {
+		if err := c.ImportCA(test.name, test.cert, test.key); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("ImportCA(%v, %v) = %v, want an error containing %q", test.name, test.cert.Subject.CommonName, err, test.err)
+		}
+	}
+	if err := c.ImportCA("Missing CA", root, rootKey); !errors.Is(err, config.ErrNotFound) {
+		t.Errorf("ImportCA() of an undefined CA = %v, want %v", err, config.ErrNotFound)
+	}
+
+	if err := c.ImportCA("Legacy Root", root, rootKey); err != nil {
+		t.Fatalf("ImportCA(Legacy Root) failed: %v", err)
+	}
+	if err := c.ImportCA("Legacy Intermediate", forged, forgedKey); err == nil || !strings.Contains(err.Error(), "not signed by Legacy Root") {
+		t.Errorf("ImportCA() of an intermediate signed by another root = %v, want a signature error", err)
+	}
+	if err := c.ImportCA("Legacy Intermediate", mid, midKey); err != nil {
+		t.Fatalf("ImportCA(Legacy Intermediate) failed: %v", err)
+	}
+	// The signature of a CA signed outside of the PKI is not checked.
+	partner, partnerKey := intermediate(t, "Partner CA", other, otherKey)
+	if err := c.ImportCA("Partner CA", partner, partnerKey); err != nil {
+		t.Errorf("ImportCA(Partner CA) failed: %v", err)
+	}
+
+	res = results(t, c)
+	if got := res["web"].Action; got != config.Created {
+		t.Errorf("web action after the imports = %v, want %v", got, config.Created)
+	}
+	web, err := c.GetBundle("Legacy Intermediate", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates.AddCert(mid)
+	if _, err := web.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "web.example.com"}); err != nil {
+		t.Errorf("web does not verify up to the imported root: %v", err)
+	}
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// varName matches the names of configuration variables.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// variable is an entry of the vars section of a configuration file.
+type variable struct {
+	name  string
+	value string
+	line  int
+}
+
+// variables resolves the references in configuration values. The
+// environment takes precedence over the vars sections of the files, which
+// only act as defaults.
+type variables struct {
+	vars map[string]string
+	// undefined collects the references to variables without a value.
+	undefined map[string]bool
+}
+
+func (v *variables) lookup(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	value, ok := v.vars[name]
+	return value, ok
+}
+
+// expand replaces ${VAR} and ${VAR:-default} in s, the default being used
+// when VAR is unset or empty. $${ stands for a literal ${. References to
+// undefined variables are collected rather than failing right away, so
+// every one of them is reported.
+func (v *variables) expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var out strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			out.WriteString(s)
+			return out.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			out.WriteString(s[:i])
+			out.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		out.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s[i:])
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDefault := ref, "", false
+		if j := strings.Index(ref, ":-"); j >= 0 {
+			name, def, hasDefault = ref[:j], ref[j+2:], true
+		}
+		if !varName.MatchString(name) {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+
+		value, ok := v.lookup(name)
+		switch {
+		case hasDefault && value == "":
+			value = def
+		case !ok:
+			if v.undefined == nil {
+				v.undefined = map[string]bool{}
+			}
+			v.undefined[name] = true
+		}
+		out.WriteString(value)
+	}
+}
+
+// check returns an error naming the undefined variables referenced since the
+// last check.
+func (v *variables) check() error {
+	if len(v.undefined) == 0 {
+		return nil
+	}
+
+	var names []string
+	for name := range v.undefined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	v.undefined = nil
+
+	if len(names) == 1 {
+		return fmt.Errorf("undefined variable %v", names[0])
+	}
+	return fmt.Errorf("undefined variables %v", strings.Join(names, ", "))
+}
+
+// expandNode returns a copy of n with the references in its scalars
+// expanded. Aliases are replaced by copies of their anchors, so anchored
+// values are expanded once.
+func (v *variables) expandNode(n *yaml.Node) (*yaml.Node, error) {
+	copies := map[*yaml.Node]*yaml.Node{}
+	var walk func(n *yaml.Node) (*yaml.Node, error)
+	walk = func(n *yaml.Node) (*yaml.Node, error) {
+		if c, ok := copies[n]; ok {
+			return c, nil
+		}
+		if n.Kind == yaml.AliasNode {
+			c, err := walk(n.Alias)
+			copies[n] = c
+			return c, err
+		}
+
+		c := *n
+		copies[n] = &c
+		if n.Kind == yaml.ScalarNode {
+			value, err := v.expand(n.Value)
+			if err != nil {
+				return nil, &lineError{Line: n.Line, Err: err}
+			}
+			if value != n.Value && n.Style&^yaml.FlowStyle == 0 {
+				// Plain scalars are resolved again, so a reference
+				// can stand for a number or a boolean.
+				c.Tag = ""
+			}
+			c.Value = value
+			return &c, nil
+		}
+
+		c.Content = make([]*yaml.Node, len(n.Content))
+		for i, item := range n.Content {
+			var err error
+			if c.Content[i], err = walk(item); err != nil {
+				return nil, err
+			}
+		}
+		return &c, nil
+	}
+
+	return walk(n)
+}
+
+// expandValue expands the references in the strings of a generic decoded
+// value, reporting whether any changed.
+func (v *variables) expandValue(value interface{}) (interface{}, bool, error) {
+	switch value := value.(type) {
+	case string:
+		s, err := v.expand(value)
+		return s, s != value, err
+	case []interface{}:
+		changed := false
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			var c bool
+			var err error
+			if out[i], c, err = v.expandValue(item); err != nil {
+				return nil, false, err
+			}
+			changed = changed || c
+		}
+		return out, changed, nil
+	case []map[string]interface{}:
+		changed := false
+		out := make([]map[string]interface{}, len(value))
+		for i, item := range value {
+			m, c, err := v.expandValue(item)
+			if err != nil {
+				return nil, false, err
+			}
+			out[i], changed = m.(map[string]interface{}), changed || c
+		}
+		return out, changed, nil
+	case map[string]interface{}:
+		changed := false
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			var c bool
+			var err error
+			if out[k], c, err = v.expandValue(item); err != nil {
+				return nil, false, err
+			}
+			changed = changed || c
+		}
+		return out, changed, nil
+	default:
+		return value, false, nil
+	}
+}
+
+// scalarString formats the value of a variable given as a number or a
+// boolean rather than a string.
+func scalarString(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool, int64, float64, fmt.Stringer:
+		return fmt.Sprint(value), nil
+	default:
+		return "", errors.New("value is not a scalar")
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("DOMAIN", "example.com")
+	t.Setenv("EMPTY", "")
+	v := &variables{vars: map[string]string{"DOMAIN": "example.org", "STAGE": "prod"}}
+
+	tests := []struct {
+		in, want string
+		// err is part of the expected error, undefined the variables
+		// expected to be reported by check.
+		err       string
+		undefined string
+	}{
+		{in: "web.example.com", want: "web.example.com"},
+		{in: "web.${DOMAIN}", want: "web.example.com"},
+		{in: "${STAGE}.${DOMAIN}", want: "prod.example.com"},
+		{in: "${EMPTY}", want: ""},
+		{in: "${EMPTY:-fallback}", want: "fallback"},
+		{in: "${UNSET:-fallback}", want: "fallback"},
+		{in: "${UNSET:-}", want: ""},
+		{in: "${DOMAIN:-fallback}", want: "example.com"},
+		{in: "$${DOMAIN}", want: "${DOMAIN}"},
+		{in: "$$${DOMAIN}", want: "$${DOMAIN}"},
+		{in: "cost: $5", want: "cost: $5"},
+		{in: "${UNSET}", undefined: "undefined variable UNSET"},
+		{in: "${UNSET}.${OTHER}", want: ".", undefined: "undefined variables OTHER, UNSET"},
+		{in: "${DOMAIN", err: "unterminated variable reference"},
+		{in: "${1DOMAIN}", err: "invalid variable name"},
+	}
+	for _, test := range tests {
+		got, err := v.expand(test.in)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expand(%q) = %q, %v; want an error containing %q", test.in, got, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("expand(%q) = %q, %v; want %q", test.in, got, err, test.want)
+		}
+		undefined := v.check()
+		if test.undefined == "" && undefined != nil || test.undefined != "" && (undefined == nil || undefined.Error() != test.undefined) {
+			t.Errorf("check() after expand(%q) = %v; want %q", test.in, undefined, test.undefined)
+		}
+	}
+}
+
+func TestInterpolateLoad(t *testing.T) {
+	t.Setenv("IS_CA", "true")
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"pki.yml": `include: [pki.json, pki.toml]
+vars:
+  DOMAIN: example.com
+  VALIDITY: ${VALIDITY_OVERRIDE:-8760h}
+certs:
+  - name: Root CA
+    commonName: ca.${DOMAIN}
+    isCA: ${IS_CA}
+    expire: ${VALIDITY}
+`,
+		"pki.json": `{"certs": [{"name": "web", "commonName": "web.${DOMAIN}", "signer": "Root CA", "dnsNames": ["$${literal}"]}]}`,
+		"pki.toml": `[[certs]]
+name = "mail"
+commonName = "mail.${DOMAIN}"
+signer = "Root CA"
+`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defs, err := (&Yaml{Path: filepath.Join(dir, "pki.yml")}).Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	certs := map[string]Cert{}
+	for _, cert := range defs.Certs {
+		certs[cert.Name] = cert
+	}
+	if root := certs["Root CA"]; root.CommonName != "ca.example.com" || !root.IsCA || root.Expire.String() != "8760h0m0s" {
+		t.Errorf("Root CA = %+v, want ca.example.com, a CA, expiring after 8760h", root)
+	}
+	if web := certs["web"]; web.CommonName != "web.example.com" || !reflect.DeepEqual(web.DNSNames, []string{"${literal}"}) {
+		t.Errorf("web = %+v, want web.example.com and a literal ${literal}", web)
+	}
+	if mail := certs["mail"]; mail.CommonName != "mail.example.com" {
+		t.Errorf("mail commonName = %q, want mail.example.com", mail.CommonName)
+	}
+
+	// References to undefined variables are reported where they are.
+	path := filepath.Join(dir, "undefined.yml")
+	err = ioutil.WriteFile(path, []byte(`certs:
+  - name: Root CA
+    commonName: ca.${DOMAIN}
+    isCA: true
+  - name: web
+    commonName: web.${DOMAIN}
+    signer: Root CA
+    dnsNames:
+      - web.${DOMAIN}
+      - www.${ZONE}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&Yaml{Path: path}).Load()
+	for _, want := range []string{
+		path + ":2: Root CA: undefined variable DOMAIN",
+		path + ":5: web: undefined variables DOMAIN, ZONE",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() = %v, want %q", err, want)
+		}
+	}
+}
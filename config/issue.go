@@ -0,0 +1,209 @@
+package config
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+)
+
+// makeCert issues cert with its resolved profile p and saves the bundle in
+// the easypki store, signed by the configured signer or by itself. signerDef
+// is the signer's definition, whose URLs the certificate carries. Issuing
+// fails when the certificate's names violate the name constraints of its
+// signer or of the CAs listed in chain, the CAs above the signer.
+func (c *Config) makeCert(cert Cert, signerDef *Cert, p Profile, chain []string) error {
+	defer c.resetOCSP()
+
+	var signer *Bundle
+	if !selfSigned(cert) {
+		var err error
+		signer, err = c.GetCA(cert.Signer)
+		if err != nil {
+			return fmt.Errorf("cannot sign %v because cannot get CA %v: %v", cert.Name, cert.Signer, err)
+		}
+	}
+	if signer == nil && !cert.IsCA {
+		return fmt.Errorf("cannot create bundle for %v: only CAs can be self signed", cert.Name)
+	}
+	if signer != nil && cert.IsCA && signer.Cert.MaxPathLen == 0 {
+		return fmt.Errorf("cannot create bundle for %v: CA %v cannot sign further CAs", cert.Name, cert.Signer)
+	}
+
+	// Certificates issued for a request are signed for its key, which is
+	// never held here.
+	var key crypto.Signer
+	var pub crypto.PublicKey
+	var err error
+	if cert.CSR != "" {
+		if pub, err = requestKey(cert); err != nil {
+			return fmt.Errorf("cannot create bundle for %v: %v", cert.Name, err)
+		}
+	} else {
+		if key, err = generateKey(cert); err != nil {
+			return fmt.Errorf("failed generating private key for %v: %v", cert.Name, err)
+		}
+		pub = key.Public()
+	}
+	tmpl, err := certTemplate(cert, p, pub)
+	if err != nil {
+		return fmt.Errorf("cannot create bundle for %v: %v", cert.Name, err)
+	}
+
+	if signer != nil {
+		if err := c.checkChainConstraints(tmpl, signer, chain); err != nil {
+			return fmt.Errorf("cannot create bundle for %v: %v", cert.Name, err)
+		}
+	}
+
+	parent, signerKey, signerName := tmpl, key, cert.Name
+	if signer != nil {
+		parent, signerKey, signerName = signer.Cert, signer.Key, signer.Name
+		if notAfter, clamped := clamp(tmpl.NotAfter, signer.Cert); clamped {
+			log.Printf("Warning: %v would outlive its signer %v, clamping its notAfter from %v to %v",
+				cert.Name, cert.Signer, tmpl.NotAfter.Format(time.RFC3339), notAfter.Format(time.RFC3339))
+			tmpl.NotAfter = notAfter
+		}
+		if cert.IsCA {
+			tmpl.MaxPathLen = pathLen(p, signer.Cert)
+			tmpl.MaxPathLenZero = tmpl.MaxPathLen == 0
+		}
+		if signerDef != nil {
+			signerDef.URLs.apply(tmpl, signerDef.Name)
+		}
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signerKey)
+	if err != nil {
+		return fmt.Errorf("failed creating and signing certificate %v: %v", cert.Name, err)
+	}
+	rawKey := []byte{}
+	if key != nil {
+		if rawKey, err = MarshalPrivateKey(key); err != nil {
+			return fmt.Errorf("cannot create bundle for %v: %v", cert.Name, err)
+		}
+	}
+	if signer != nil {
+		if err := c.supersede(signer.Name, cert.Name, signer.Cert); err != nil {
+			return err
+		}
+	}
+	if err := c.EasyPKI.Store.Add(signerName, cert.Name, cert.IsCA, rawKey, raw); err != nil {
+		return fmt.Errorf("failed saving bundle for %v: %v", cert.Name, err)
+	}
+
+	return nil
+}
+
+// checkChainConstraints checks the names in tmpl against the name
+// constraints of signer and of every CA in chain.
+func (c *Config) checkChainConstraints(tmpl *x509.Certificate, signer *Bundle, chain []string) error {
+	if err := checkNameConstraints(tmpl, signer.Cert); err != nil {
+		return err
+	}
+	for _, name := range chain {
+		if name == signer.Name {
+			continue
+		}
+		ca, err := c.GetCA(name)
+		if err != nil {
+			return fmt.Errorf("cannot check name constraints of CA %v: %v", name, err)
+		}
+		if err := checkNameConstraints(tmpl, ca.Cert); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// certTemplate builds the certificate issued for cert with profile p to the
+// given public key.
+func certTemplate(cert Cert, p Profile, pub crypto.PublicKey) (*x509.Certificate, error) {
+	keyUsage, err := p.keyUsage()
+	if err != nil {
+		return nil, err
+	}
+	extKeyUsage, err := p.extKeyUsage()
+	if err != nil {
+		return nil, err
+	}
+	if p.Expire <= 0 && cert.NotAfter == nil {
+		return nil, errors.New("expire must be a positive duration")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed generating serial number: %v", err)
+	}
+	skid, err := subjectKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := parseIPs(cert.IPAddresses)
+	if err != nil {
+		return nil, err
+	}
+	uris, err := parseURIs(cert.URIs)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore, notAfter := window(cert, p, time.Now())
+	if !notAfter.After(notBefore) {
+		return nil, fmt.Errorf("notAfter %v is not after notBefore %v", notAfter.Format(time.RFC3339), notBefore.Format(time.RFC3339))
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        cert.Subject.Name(cert.CommonName),
+		NotBefore:      notBefore,
+		NotAfter:       notAfter,
+		KeyUsage:       keyUsage,
+		ExtKeyUsage:    extKeyUsage,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    ips,
+		URIs:           uris,
+		SubjectKeyId:   skid,
+	}
+	if tmpl.ExtraExtensions, err = extraExtensions(cert); err != nil {
+		return nil, err
+	}
+	if cert.IsCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.MaxPathLen = pathLen(p, nil)
+		tmpl.MaxPathLenZero = tmpl.MaxPathLen == 0
+		if err := cert.NameConstraints.apply(tmpl); err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpl, nil
+}
+
+// subjectKeyID derives the key identifier from the public key as described
+// in RFC 5280 section 4.2.1.2, method 1.
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling public key: %v", err)
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, fmt.Errorf("failed parsing public key: %v", err)
+	}
+	id := sha1.Sum(spki.PublicKey.Bytes)
+
+	return id[:], nil
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// parseJSON parses a JSON configuration file. Like YAML files, entries are
+// decoded one by one and reported with the line they start at.
+func parseJSON(f *fragment, b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	invalid := func(err error) error {
+		return fmt.Errorf("failed unmarshaling json config (%v): %v", f.path, err)
+	}
+
+	if tok, err := dec.Token(); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return invalid(err)
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("invalid json config (%v): configuration root is not an object", f.path)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return invalid(err)
+		}
+		key, _ := tok.(string)
+		at := lineOf(b, dec.InputOffset())
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return invalid(err)
+		}
+
+		switch key {
+		case "certs":
+			if err := parseJSONCerts(f, value, at); err != nil {
+				return invalid(err)
+			}
+		case "profiles":
+			err := parseJSONObject(f, "profiles", value, at, func(name string, line int, item json.RawMessage) {
+				f.profiles = append(f.profiles, entry{name: name, line: line, decode: jsonDecoder(item)})
+			})
+			if err != nil {
+				return invalid(err)
+			}
+		case "vars":
+			err := parseJSONObject(f, "vars", value, at, func(name string, line int, item json.RawMessage) {
+				var v interface{}
+				dec := json.NewDecoder(bytes.NewReader(item))
+				dec.UseNumber()
+				dec.Decode(&v)
+				value, err := scalarString(v)
+				if err != nil {
+					f.fail("variable "+name, line, err)
+					return
+				}
+				f.vars = append(f.vars, variable{name: name, value: value, line: line})
+			})
+			if err != nil {
+				return invalid(err)
+			}
+		case "include":
+			var pattern string
+			switch {
+			case string(value) == "null":
+			case json.Unmarshal(value, &pattern) == nil:
+				f.include, f.includeLine = []string{pattern}, at
+			case json.Unmarshal(value, &f.include) == nil:
+				f.includeLine = at
+			default:
+				f.fail("", at, errors.New("include is not a glob or a list of globs"))
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseJSONCerts decodes the certs array starting at line start.
+func parseJSONCerts(f *fragment, value json.RawMessage, start int) error {
+	if string(value) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('[') {
+		f.fail("", start, errors.New("certs is not a list"))
+		return nil
+	}
+
+	for dec.More() {
+		at := start + lineOf(value, dec.InputOffset()) - 1
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		var named struct{ Name string }
+		json.Unmarshal(item, &named)
+		f.certs = append(f.certs, entry{name: named.Name, line: at, decode: jsonDecoder(item)})
+	}
+
+	return nil
+}
+
+// parseJSONObject calls fn with the members of the object key starting at
+// line start.
+func parseJSONObject(f *fragment, key string, value json.RawMessage, start int, fn func(name string, line int, item json.RawMessage)) error {
+	if string(value) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(value))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		f.fail("", start, fmt.Errorf("%v is not a mapping", key))
+		return nil
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		name, _ := tok.(string)
+		at := start + lineOf(value, dec.InputOffset()) - 1
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		fn(name, at, item)
+	}
+
+	return nil
+}
+
+// jsonDecoder returns the decode function of the entry item.
+func jsonDecoder(item json.RawMessage) func(vars *variables, v interface{}) error {
+	return func(vars *variables, v interface{}) error {
+		var raw interface{}
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		expanded, changed, err := vars.expandValue(raw)
+		if err != nil {
+			return err
+		}
+		b := item
+		if changed {
+			if b, err = json.Marshal(expanded); err != nil {
+				return err
+			}
+		}
+		return json.Unmarshal(b, v)
+	}
+}
+
+// lineOf returns the line of the value following offset in b. Decoder
+// offsets point right after the previous token, before any separators.
+func lineOf(b []byte, offset int64) int {
+	for offset < int64(len(b)) && bytes.IndexByte([]byte(" \t\r\n,:"), b[offset]) >= 0 {
+		offset++
+	}
+
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
+
+func decodeJSONDocument(b []byte, doc *document) error {
+	var raw struct {
+		document
+		Profiles map[string]json.RawMessage `json:"profiles"`
+		Certs    []json.RawMessage          `json:"certs"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*doc = raw.document
+	for name, item := range raw.Profiles {
+		var p Profile
+		if doc.Profiles == nil {
+			doc.Profiles = map[string]interface{}{}
+		}
+		doc.Profiles[name] = item
+		if json.Unmarshal(item, &p) == nil {
+			doc.Profiles[name] = p
+		}
+	}
+	for _, item := range raw.Certs {
+		var cert Cert
+		if json.Unmarshal(item, &cert) == nil {
+			doc.Certs = append(doc.Certs, cert)
+		} else {
+			doc.Certs = append(doc.Certs, item)
+		}
+	}
+
+	return nil
+}
+
+func encodeJSONDocument(doc *document) ([]byte, error) {
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
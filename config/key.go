@@ -0,0 +1,145 @@
+package config
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Key algorithms a certificate can be issued with.
+const (
+	RSA     = "rsa"
+	ECDSA   = "ecdsa"
+	Ed25519 = "ed25519"
+)
+
+// Key sizes used when a certificate does not set one.
+const (
+	defaultRSAKeySize   = 2048
+	defaultECDSAKeySize = 256
+)
+
+var curves = map[int]elliptic.Curve{
+	256: elliptic.P256(),
+	384: elliptic.P384(),
+	521: elliptic.P521(),
+}
+
+// keyParams returns the algorithm and size cert's key is generated with,
+// applying the defaults.
+func keyParams(cert Cert) (string, int, error) {
+	switch cert.KeyAlgorithm {
+	case "", RSA:
+		size := cert.KeySize
+		if size == 0 {
+			size = defaultRSAKeySize
+		}
+		if size < 2048 || size%1024 != 0 {
+			return "", 0, fmt.Errorf("rsa keySize must be a multiple of 1024 of at least 2048, got %d", size)
+		}
+		return RSA, size, nil
+	case ECDSA:
+		size := cert.KeySize
+		if size == 0 {
+			size = defaultECDSAKeySize
+		}
+		if _, ok := curves[size]; !ok {
+			return "", 0, fmt.Errorf("ecdsa keySize must be 256, 384 or 521, got %d", size)
+		}
+		return ECDSA, size, nil
+	case Ed25519:
+		if cert.KeySize != 0 {
+			return "", 0, fmt.Errorf("ed25519 keys do not take a keySize, got %d", cert.KeySize)
+		}
+		return Ed25519, 0, nil
+	default:
+		return "", 0, fmt.Errorf("unknown keyAlgorithm %v, expected %v, %v or %v", cert.KeyAlgorithm, RSA, ECDSA, Ed25519)
+	}
+}
+
+// generateKey creates a new private key for cert.
+func generateKey(cert Cert) (crypto.Signer, error) {
+	alg, size, err := keyParams(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case ECDSA:
+		return ecdsa.GenerateKey(curves[size], rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return rsa.GenerateKey(rand.Reader, size)
+	}
+}
+
+// PublicKeyParams returns the algorithm and size of a certificate's public
+// key, in the terms of keyParams.
+func PublicKeyParams(pub crypto.PublicKey) (string, int) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return RSA, pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return ECDSA, pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return Ed25519, 0
+	default:
+		return "", 0
+	}
+}
+
+// MarshalPrivateKey encodes key for the easypki store. RSA keys are kept in
+// PKCS #1 form, as easypki itself stores them, other keys in PKCS #8 form.
+func MarshalPrivateKey(key crypto.Signer) ([]byte, error) {
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		return x509.MarshalPKCS1PrivateKey(k), nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshaling private key: %v", err)
+	}
+
+	return der, nil
+}
+
+// ParsePrivateKey decodes a DER private key in PKCS #1, PKCS #8 or SEC 1 form.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	return nil, errors.New("failed parsing private key: not a PKCS #1, PKCS #8 or SEC 1 key")
+}
+
+// PrivateKeyPEM returns key as a PEM block: PKCS #1 "RSA PRIVATE KEY" for RSA
+// keys, PKCS #8 "PRIVATE KEY" for the others.
+func PrivateKeyPEM(key crypto.Signer) (*pem.Block, error) {
+	der, err := MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}, nil
+	}
+
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
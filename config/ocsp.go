@@ -0,0 +1,375 @@
+package config
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrMalformedOCSP is returned for OCSP requests that cannot be parsed.
+var ErrMalformedOCSP = errors.New("malformed OCSP request")
+
+const (
+	// ocspValidity is how long OCSP responses are valid.
+	ocspValidity = time.Hour
+	// ocspSignerExpire is how long delegated OCSP signing certificates are
+	// valid. They are replaced once half of that has passed.
+	ocspSignerExpire = 7 * 24 * time.Hour
+)
+
+// ocspCache holds what OCSP responses are made from, so requests need not
+// read every bundle. It is dropped whenever anything is issued, revoked,
+// imported or reloaded and rebuilt by the next request.
+type ocspCache struct {
+	mu sync.Mutex
+	// gen counts the resets, so an index or response made from the state
+	// before one is not kept.
+	gen   int
+	index *ocspIndex
+	// responses are the signed responses for the certificates in the
+	// index, by CA, hash algorithm and serial, until their next update.
+	responses map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	raw        []byte
+	nextUpdate time.Time
+}
+
+// ocspIndex is the status of the certificates every CA issued.
+type ocspIndex struct {
+	// cas are the certificates of the issued CAs by name.
+	cas map[string]*x509.Certificate
+	// status maps each CA to the status of the certificates it issued by
+	// serial, in decimal. Serials it did not issue are not there.
+	status map[string]map[string]ocspEntry
+}
+
+type ocspEntry struct {
+	status    int
+	revokedAt time.Time
+	reason    int
+}
+
+// resetOCSP drops the OCSP index and the responses signed from it.
+func (c *Config) resetOCSP() {
+	c.ocsp.mu.Lock()
+	defer c.ocsp.mu.Unlock()
+
+	c.ocsp.gen++
+	c.ocsp.index = nil
+	c.ocsp.responses = nil
+}
+
+// OCSP answers the DER OCSP request raw for any CA of the configuration. The
+// certificate is good if it is held in the CA's bundles or was superseded by
+// a reissue and did not expire yet, revoked if the easypki store recorded
+// its revocation and unknown otherwise. Requests for CAs that are not
+// defined or not issued return ErrNotFound. Responses for known certificates
+// are reused until their next update.
+func (c *Config) OCSP(raw []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(raw)
+	if err != nil {
+		return nil, ErrMalformedOCSP
+	}
+	if !req.HashAlgorithm.Available() {
+		return nil, ErrMalformedOCSP
+	}
+	idx, gen, err := c.ocspIndex()
+	if err != nil {
+		return nil, err
+	}
+	name, err := idx.issuer(req)
+	if err != nil {
+		return nil, err
+	}
+	def, err := c.Store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if def == nil {
+		return nil, ErrNotFound
+	}
+
+	entry, known := idx.status[name][req.SerialNumber.String()]
+	cacheKey := fmt.Sprintf("%v %v %v", name, req.HashAlgorithm, req.SerialNumber)
+	now := time.Now()
+	if known {
+		if resp, ok := c.cachedOCSP(cacheKey, now); ok {
+			return resp, nil
+		}
+	}
+
+	ca, err := c.GetCA(name)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	tmpl := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ocspValidity),
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if known {
+		tmpl.Status, tmpl.RevokedAt, tmpl.RevocationReason = entry.status, entry.revokedAt, entry.reason
+	}
+
+	signer, signerKey := ca.Cert, ca.Key
+	if def.DelegatedOCSP {
+		responder, err := c.ocspSigner(*def, ca)
+		if err != nil {
+			return nil, err
+		}
+		signer, signerKey = responder.Cert, responder.Key
+		tmpl.Certificate = responder.Cert
+	}
+	resp, err := ocsp.CreateResponse(ca.Cert, signer, tmpl, signerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed signing OCSP response of %v: %v", name, err)
+	}
+	// Unknown serials are not kept, anyone can ask for any number of them.
+	if known {
+		c.cacheOCSP(gen, cacheKey, cachedResponse{raw: resp, nextUpdate: tmpl.NextUpdate})
+	}
+
+	return resp, nil
+}
+
+func (c *Config) cachedOCSP(key string, now time.Time) ([]byte, bool) {
+	c.ocsp.mu.Lock()
+	defer c.ocsp.mu.Unlock()
+
+	cached, ok := c.ocsp.responses[key]
+	if !ok || !now.Before(cached.nextUpdate) {
+		return nil, false
+	}
+
+	return cached.raw, true
+}
+
+func (c *Config) cacheOCSP(gen int, key string, resp cachedResponse) {
+	c.ocsp.mu.Lock()
+	defer c.ocsp.mu.Unlock()
+
+	if gen != c.ocsp.gen {
+		return
+	}
+	if c.ocsp.responses == nil {
+		c.ocsp.responses = map[string]cachedResponse{}
+	}
+	c.ocsp.responses[key] = resp
+}
+
+// ocspIndex returns the OCSP index, building it if it was reset, and the
+// generation it belongs to.
+func (c *Config) ocspIndex() (*ocspIndex, int, error) {
+	c.ocsp.mu.Lock()
+	idx, gen := c.ocsp.index, c.ocsp.gen
+	c.ocsp.mu.Unlock()
+	if idx != nil {
+		return idx, gen, nil
+	}
+
+	idx, err := c.buildOCSPIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+	c.ocsp.mu.Lock()
+	if gen == c.ocsp.gen {
+		c.ocsp.index = idx
+	}
+	c.ocsp.mu.Unlock()
+
+	return idx, gen, nil
+}
+
+// buildOCSPIndex reads the certificates every defined CA currently issues,
+// those signed by its current certificate, the ones they superseded that
+// did not expire yet and the ones it revoked.
+func (c *Config) buildOCSPIndex() (*ocspIndex, error) {
+	lister, ok := c.EasyPKI.Store.(BundleLister)
+	if !ok {
+		return nil, errors.New("the easypki store cannot list its bundles")
+	}
+	s, err := c.revocationStore()
+	if err != nil {
+		return nil, err
+	}
+	isCA := true
+	cas, err := c.Store.List(Filter{IsCA: &isCA})
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &ocspIndex{cas: map[string]*x509.Certificate{}, status: map[string]map[string]ocspEntry{}}
+	for _, def := range cas {
+		if issued := c.fetchCert(def.Name, def.Name); issued != nil {
+			idx.cas[def.Name] = issued
+			idx.status[def.Name] = map[string]ocspEntry{}
+		}
+	}
+
+	refs, err := lister.Bundles()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing bundles: %v", err)
+	}
+	for _, ref := range refs {
+		ca := idx.cas[ref.CA]
+		if ca == nil || ref.Name == ref.CA {
+			continue
+		}
+		issued := c.fetchCert(ref.CA, ref.Name)
+		if issued != nil && issued.CheckSignatureFrom(ca) == nil {
+			idx.status[ref.CA][issued.SerialNumber.String()] = ocspEntry{status: ocsp.Good}
+		}
+	}
+
+	now := time.Now()
+	for name := range idx.cas {
+		superseded, err := s.Superseded(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching superseded certificates of %v: %v", name, err)
+		}
+		for serial, notAfter := range superseded {
+			if now.Before(notAfter) {
+				idx.status[name][serial] = ocspEntry{status: ocsp.Good}
+			}
+		}
+		revoked, err := c.EasyPKI.Store.Revoked(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching revocations of %v: %v", name, err)
+		}
+		reasons, err := s.Reasons(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed fetching revocation reasons of %v: %v", name, err)
+		}
+		for _, r := range revoked {
+			serial := r.SerialNumber.String()
+			idx.status[name][serial] = ocspEntry{status: ocsp.Revoked, revokedAt: r.RevocationTime, reason: reasons[serial]}
+		}
+	}
+
+	return idx, nil
+}
+
+// issuer returns the name of the CA whose name and key hashes req was made
+// for.
+func (idx *ocspIndex) issuer(req *ocsp.Request) (string, error) {
+	for name, ca := range idx.cas {
+		nameHash, keyHash, err := issuerHashes(ca, req.HashAlgorithm)
+		if err == nil && string(nameHash) == string(req.IssuerNameHash) && string(keyHash) == string(req.IssuerKeyHash) {
+			return name, nil
+		}
+	}
+
+	return "", ErrNotFound
+}
+
+// issuerHashes hashes the subject and the public key of ca as OCSP requests
+// identify their issuer.
+func issuerHashes(ca *x509.Certificate, hash crypto.Hash) ([]byte, []byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, fmt.Errorf("failed parsing public key: %v", err)
+	}
+	h := hash.New()
+	h.Write(ca.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.Bytes)
+
+	return nameHash, h.Sum(nil), nil
+}
+
+// supersede records the certificate name signed by the CA caName, about to
+// be replaced, so OCSP keeps answering good for it until it expires unless
+// it is revoked. Certificates signed by a former certificate of the CA, or
+// already expired or revoked, are not recorded.
+func (c *Config) supersede(caName, name string, ca *x509.Certificate) error {
+	old := c.fetchCert(caName, name)
+	if old == nil || old.CheckSignatureFrom(ca) != nil || !time.Now().Before(old.NotAfter) || c.revoked(caName, old.SerialNumber) {
+		return nil
+	}
+	s, err := c.revocationStore()
+	if err != nil {
+		return err
+	}
+	if err := s.PutSuperseded(caName, old.SerialNumber, old.NotAfter); err != nil {
+		return fmt.Errorf("failed recording superseded certificate of %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// ocspSigner returns the delegated OCSP signing certificate of the CA def,
+// issuing a new one when there is none, half of its validity has passed or
+// the CA was reissued since. Relying parties do not check its revocation.
+func (c *Config) ocspSigner(def Cert, ca *Bundle) (*Bundle, error) {
+	// Concurrent requests would otherwise each issue and save a signer.
+	c.signing.Lock()
+	defer c.signing.Unlock()
+
+	s, err := c.revocationStore()
+	if err != nil {
+		return nil, err
+	}
+	rawKey, rawCert, err := s.Responder(def.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching OCSP signer of %v: %v", def.Name, err)
+	}
+	if rawCert != nil {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err == nil && cert.CheckSignatureFrom(ca.Cert) == nil &&
+			time.Now().Before(cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore)/2)) {
+			if key, err := ParsePrivateKey(rawKey); err == nil {
+				return &Bundle{Name: def.Name, Key: key, Cert: cert}, nil
+			}
+		}
+	}
+
+	responder := Cert{
+		Name:         def.Name + " OCSP",
+		CommonName:   ca.Cert.Subject.CommonName + " OCSP Responder",
+		KeyAlgorithm: "ecdsa",
+		Extensions:   []Extension{{OID: "1.3.6.1.5.5.7.48.1.5", DER: "05:00"}},
+	}
+	p := BuiltinProfiles["ocsp-signing"]
+	p.Expire = Duration(ocspSignerExpire)
+	key, err := generateKey(responder)
+	if err != nil {
+		return nil, fmt.Errorf("failed generating OCSP signing key for %v: %v", def.Name, err)
+	}
+	tmpl, err := certTemplate(responder, p, key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("cannot create OCSP signer for %v: %v", def.Name, err)
+	}
+	tmpl.NotAfter, _ = clamp(tmpl.NotAfter, ca.Cert)
+	raw, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating OCSP signer for %v: %v", def.Name, err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing OCSP signer of %v: %v", def.Name, err)
+	}
+	if rawKey, err = MarshalPrivateKey(key); err != nil {
+		return nil, fmt.Errorf("cannot create OCSP signer for %v: %v", def.Name, err)
+	}
+	if err := s.PutResponder(def.Name, rawKey, raw); err != nil {
+		return nil, fmt.Errorf("failed saving OCSP signer of %v: %v", def.Name, err)
+	}
+
+	return &Bundle{Name: def.Name, Key: key, Cert: cert}, nil
+}
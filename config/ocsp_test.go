@@ -0,0 +1,201 @@
+package config_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspConfig = `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+  - name: Issuing CA
+    commonName: Issuing CA
+    signer: Root CA
+    isCA: true
+    expire: 4380h
+    delegatedOCSP: true
+  - name: web
+    commonName: web.acme.internal
+    signer: Root CA
+    expire: 720h
+  - name: mail
+    commonName: mail.acme.internal
+    signer: Issuing CA
+    expire: 720h
+`
+
+// askOCSP asks c about cert issued by issuer and returns the raw and the
+// parsed response, checked to be signed for issuer.
+func askOCSP(t *testing.T, c *config.Config, cert, issuer *x509.Certificate) ([]byte, *ocsp.Response) {
+	t.Helper()
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := c.OCSP(req)
+	if err != nil {
+		t.Fatalf("OCSP: %v", err)
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, cert, issuer)
+	if err != nil {
+		t.Fatalf("failed parsing OCSP response: %v", err)
+	}
+
+	return raw, resp
+}
+
+func TestOCSPDelegated(t *testing.T) {
+	c := newConfig(t, ocspConfig)
+	results(t, c)
+	ca, err := c.GetCA("Issuing CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail, err := c.GetBundle("Issuing CA", "mail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, resp := askOCSP(t, c, mail.Cert, ca.Cert)
+	if resp.Status != ocsp.Good {
+		t.Errorf("mail is %v; want good", resp.Status)
+	}
+	signer := resp.Certificate
+	if signer == nil {
+		t.Fatal("the response does not carry the delegated signer")
+	}
+	if len(signer.ExtKeyUsage) != 1 || signer.ExtKeyUsage[0] != x509.ExtKeyUsageOCSPSigning {
+		t.Errorf("the delegated signer has extended key usages %v; want OCSP signing", signer.ExtKeyUsage)
+	}
+	if err := signer.CheckSignatureFrom(ca.Cert); err != nil {
+		t.Errorf("the delegated signer is not signed by Issuing CA: %v", err)
+	}
+	if again, _ := askOCSP(t, c, mail.Cert, ca.Cert); string(again) != string(raw) {
+		t.Error("the response was signed again before its next update")
+	}
+
+	if err := c.Revoke("Issuing CA", "mail", "keyCompromise"); err != nil {
+		t.Fatal(err)
+	}
+	_, resp = askOCSP(t, c, mail.Cert, ca.Cert)
+	if resp.Status != ocsp.Revoked || resp.RevocationReason != ocsp.KeyCompromise {
+		t.Errorf("mail is %v for reason %v after revoking it; want revoked for key compromise", resp.Status, resp.RevocationReason)
+	}
+	if resp.Certificate == nil || !resp.Certificate.Equal(signer) {
+		t.Error("the delegated signer was not reused")
+	}
+
+	_, resp = askOCSP(t, c, &x509.Certificate{SerialNumber: big.NewInt(42)}, ca.Cert)
+	if resp.Status != ocsp.Unknown {
+		t.Errorf("serial 42 is %v; want unknown", resp.Status)
+	}
+}
+
+func TestOCSP(t *testing.T) {
+	c := newConfig(t, ocspConfig)
+	results(t, c)
+	root, err := c.GetCA("Root CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	web, err := c.GetBundle("Root CA", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, resp := askOCSP(t, c, web.Cert, root.Cert)
+	if resp.Status != ocsp.Good || resp.Certificate != nil {
+		t.Errorf("web is %v signed by %v; want good signed by Root CA itself", resp.Status, resp.Certificate)
+	}
+	if d := resp.NextUpdate.Sub(resp.ThisUpdate); d != time.Hour {
+		t.Errorf("the response is valid for %v; want 1h", d)
+	}
+
+	// A certificate replaced because its definition changed stays good
+	// until it expires.
+	def, err := c.Store.Get("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def.DNSNames = []string{"www.acme.internal"}
+	if err := c.Store.Update("web", *def); err != nil {
+		t.Fatal(err)
+	}
+	results(t, c)
+	changed, err := c.GetBundle("Root CA", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Cert.SerialNumber.Cmp(web.Cert.SerialNumber) == 0 {
+		t.Fatal("changing web did not reissue it")
+	}
+	if _, resp := askOCSP(t, c, web.Cert, root.Cert); resp.Status != ocsp.Good {
+		t.Errorf("the superseded web is %v; want good", resp.Status)
+	}
+	web = changed
+
+	// A revoked certificate stays revoked once reissued.
+	if err := c.Revoke("Root CA", "web", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reissue("web"); err != nil {
+		t.Fatal(err)
+	}
+	reissued, err := c.GetBundle("Root CA", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, resp := askOCSP(t, c, web.Cert, root.Cert); resp.Status != ocsp.Revoked {
+		t.Errorf("the revoked web is %v; want revoked", resp.Status)
+	}
+	if _, resp := askOCSP(t, c, reissued.Cert, root.Cert); resp.Status != ocsp.Good {
+		t.Errorf("the reissued web is %v; want good", resp.Status)
+	}
+}
+
+func TestOCSPErrors(t *testing.T) {
+	c := newConfig(t, ocspConfig)
+	results(t, c)
+
+	if _, err := c.OCSP([]byte("not a request")); err != config.ErrMalformedOCSP {
+		t.Errorf("OCSP of garbage = %v; want ErrMalformedOCSP", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "Other CA"}}, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(2)}, other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.OCSP(req); err != config.ErrNotFound {
+		t.Errorf("OCSP for a CA outside of the PKI = %v; want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Plan lists what reconciling the configuration with the issued bundles
+// would do, in the order it has to be done.
+type Plan struct {
+	Steps []Step `json:"steps"`
+}
+
+// Step is the planned action for a single certificate.
+type Step struct {
+	Name   string `json:"name"`
+	Signer string `json:"signer,omitempty"`
+	// Subject is the distinguished name the certificate is issued with,
+	// after inheritance and templates are resolved.
+	Subject string `json:"subject,omitempty"`
+	// Source is where the certificate is defined, when known.
+	Source  string   `json:"source,omitempty"`
+	Action  Action   `json:"action"`
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+
+	cert    Cert
+	profile Profile
+	// signer is the resolved definition of the CA signing the certificate,
+	// nil for roots.
+	signer *Cert
+	// chain lists the CAs above the certificate, nearest first.
+	chain []string
+	// awaiting is set when the certificate cannot sign anything until an
+	// externally signed certificate is imported, its own or one above it, or
+	// a revoked CA above it is reissued.
+	awaiting bool
+}
+
+// BundleRef identifies a bundle in the easypki store.
+type BundleRef struct {
+	CA   string
+	Name string
+}
+
+// BundleLister is implemented by easypki stores able to enumerate the
+// bundles they hold. Orphaned bundles can only be planned for such stores.
+type BundleLister interface {
+	Bundles() ([]BundleRef, error)
+}
+
+// Plan compares the configured tree with the issued bundles. Certificates
+// below one that gets created or re-issued are planned for re-issue as their
+// signer changes. Revoked certificates, and those below them, are pending
+// until Reissue is asked to replace them.
+func (c *Config) Plan() (*Plan, error) {
+	tree, err := c.Store.Tree()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := storeProfiles(c.Store)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.planTree(tree, profiles)
+}
+
+func (c *Config) planTree(tree []TreeNode, profiles map[string]Profile) (*Plan, error) {
+	plan := &Plan{}
+	configured := map[string]bool{}
+	for _, node := range tree {
+		c.planNode(plan, node, profiles, nil, nil, configured)
+	}
+
+	lister, ok := c.EasyPKI.Store.(BundleLister)
+	if !ok {
+		return plan, nil
+	}
+	bundles, err := lister.Bundles()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing issued bundles: %v", err)
+	}
+	orphans := map[string]string{}
+	for _, b := range bundles {
+		if configured[b.Name] {
+			continue
+		}
+		// CAs are stored both in their signer's bucket and their own, keep
+		// the signer.
+		if _, seen := orphans[b.Name]; !seen || b.CA != b.Name {
+			orphans[b.Name] = b.CA
+		}
+	}
+	var names []string
+	for name := range orphans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		signer := orphans[name]
+		if signer == name {
+			signer = ""
+		}
+		plan.Steps = append(plan.Steps, Step{Name: name, Signer: signer, Action: Orphaned})
+	}
+
+	return plan, nil
+}
+
+// planNode plans node and everything below it. parent is the step of the CA
+// above node, nil for roots.
+func (c *Config) planNode(plan *Plan, node TreeNode, profiles map[string]Profile, chain []string, parent *Step, configured map[string]bool) {
+	var signer *Cert
+	if parent != nil {
+		signer = &parent.cert
+	}
+	cert := node.Self()
+	configured[cert.Name] = true
+	step := Step{Name: cert.Name, Signer: cert.Signer, Source: cert.Source.String(), Action: Unchanged, signer: signer, chain: chain}
+
+	cert, err := resolveSubject(cert, signer)
+	step.cert = cert
+	if err == nil {
+		step.Subject = cert.Subject.Name(cert.CommonName).String()
+		step.profile, err = resolveProfile(cert, profiles)
+	}
+	p := step.profile
+	if err != nil {
+		step.Action = Failed
+		step.Error = err.Error()
+	} else if cert.External {
+		c.planExternal(&step, cert)
+	} else if cert.Imported {
+		c.planImported(&step, cert)
+	} else if parent != nil && parent.awaiting {
+		step.Action = Pending
+		step.awaiting = true
+	} else if existing := c.issuedCert(cert); existing == nil {
+		step.Action = Created
+	} else {
+		step.Changes = c.changes(cert, signer, p, existing)
+		if signerChanging(parent) && !contains(step.Changes, "signer") {
+			step.Changes = append(step.Changes, "signer")
+		}
+		// Revoked certificates are only reissued on request, or once their
+		// definition changes.
+		if len(step.Changes) == 1 && step.Changes[0] == "revoked" {
+			step.Action = Pending
+			step.awaiting = true
+		} else if len(step.Changes) > 0 {
+			step.Action = Reissued
+		}
+	}
+	plan.Steps = append(plan.Steps, step)
+
+	below := append([]string{cert.Name}, chain...)
+	for _, child := range node.Children() {
+		c.planNode(plan, child, profiles, below, &step, configured)
+	}
+}
+
+// signerChanging reports whether the CA planned in parent gets a new
+// certificate, which the certificates it signs have to be re-issued by.
+func signerChanging(parent *Step) bool {
+	return parent != nil && (parent.Action == Created || parent.Action == Reissued)
+}
+
+// Apply executes the plan, issuing every certificate planned to be created
+// or re-issued and generating the requests of externally signed CAs. It
+// returns the outcome of every step. Certificates below one that failed are
+// not issued and fail as well.
+func (c *Config) Apply(plan *Plan) []Result {
+	var results []Result
+	failed := map[string]bool{}
+	for _, step := range plan.Steps {
+		res := Result{Name: step.Name, Signer: step.Signer, Action: step.Action, Changes: step.Changes}
+		if step.Error != "" {
+			res.Err = errors.New(step.Error)
+		}
+		var err error
+		switch step.Action {
+		case Created, Reissued:
+			if signerFailed(step.chain, failed) {
+				err = errors.New("signer failed")
+				break
+			}
+			err = c.makeCert(step.cert, step.signer, step.profile, step.chain)
+		case Requested:
+			err = c.requestCert(step.cert, step.profile)
+		}
+		if err != nil {
+			res.Action = Failed
+			res.Err = err
+		}
+		if res.Action == Failed {
+			failed[step.Name] = true
+		}
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// signerFailed reports whether any of the CAs in chain failed.
+func signerFailed(chain []string, failed map[string]bool) bool {
+	for _, name := range chain {
+		if failed[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasChanges reports whether applying the plan would issue or request
+// anything.
+func (p *Plan) HasChanges() bool {
+	for _, step := range p.Steps {
+		if step.Action == Created || step.Action == Reissued || step.Action == Requested {
+			return true
+		}
+	}
+
+	return false
+}
+
+var planSymbols = map[Action]string{
+	Created:   "+",
+	Reissued:  "~",
+	Orphaned:  "-",
+	Failed:    "!",
+	Requested: ">",
+	Pending:   "?",
+	Unchanged: " ",
+}
+
+// Write prints the plan in a human readable form, leaving out unchanged
+// certificates.
+func (p *Plan) Write(w io.Writer) error {
+	counts := map[Action]int{}
+	for _, step := range p.Steps {
+		counts[step.Action]++
+		if step.Action == Unchanged {
+			continue
+		}
+		line := fmt.Sprintf("%v %v", planSymbols[step.Action], step.Name)
+		if step.Signer != "" {
+			line += fmt.Sprintf(" (signed by %v)", step.Signer)
+		}
+		line += fmt.Sprintf(": %v", step.Action)
+		if len(step.Changes) > 0 {
+			line += fmt.Sprintf(" [%v]", strings.Join(step.Changes, ", "))
+		}
+		if step.Error != "" {
+			if step.Source != "" {
+				line += fmt.Sprintf(" at %v", step.Source)
+			}
+			line += fmt.Sprintf(": %v", step.Error)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	summary := fmt.Sprintf("Plan: %d to create, %d to reissue, %d orphaned, %d unchanged",
+		counts[Created], counts[Reissued], counts[Orphaned], counts[Unchanged])
+	if counts[Requested] > 0 {
+		summary += fmt.Sprintf(", %d to request", counts[Requested])
+	}
+	if counts[Pending] > 0 {
+		summary += fmt.Sprintf(", %d pending", counts[Pending])
+	}
+	if counts[Failed] > 0 {
+		summary += fmt.Sprintf(", %d failed", counts[Failed])
+	}
+	_, err := fmt.Fprintln(w, summary+".")
+	return err
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
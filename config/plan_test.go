@@ -0,0 +1,170 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+	"github.com/google/easypki/pkg/easypki"
+	"github.com/google/easypki/pkg/store"
+)
+
+// newConfig returns a configuration defined by the YAML data, issuing into a
+// fresh bolt database.
+func newConfig(t *testing.T, data string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pki.yml")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &config.Config{
+		Store:   &config.Yaml{Path: path},
+		EasyPKI: &easypki.EasyPKI{Store: &config.BoltPKI{Bolt: store.Bolt{DB: openBolt(t)}}},
+	}
+}
+
+func results(t *testing.T, c *config.Config) map[string]config.Result {
+	t.Helper()
+	res, err := c.Init()
+	if err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	byName := map[string]config.Result{}
+	for _, r := range res {
+		byName[r.Name] = r
+	}
+
+	return byName
+}
+
+// The signing CA cannot be issued under a CA with maxPathLen 0, so neither
+// anything below it may be.
+func TestApplySignerFailed(t *testing.T) {
+	c := newConfig(t, `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+  - name: Policy CA
+    commonName: Policy CA
+    signer: Root CA
+    isCA: true
+    expire: 4380h
+    maxPathLen: 0
+  - name: Issuing CA
+    commonName: Issuing CA
+    signer: Policy CA
+    isCA: true
+    expire: 2190h
+  - name: web
+    commonName: web.acme.internal
+    signer: Issuing CA
+  - name: api
+    commonName: api.acme.internal
+    signer: Policy CA
+    expire: 720h
+`)
+	res := results(t, c)
+	for _, name := range []string{"Root CA", "Policy CA", "api"} {
+		if res[name].Action != config.Created || res[name].Err != nil {
+			t.Errorf("%v = %v; want created", name, res[name])
+		}
+	}
+	if r := res["Issuing CA"]; r.Action != config.Failed || r.Err == nil {
+		t.Errorf("Issuing CA = %v; want failed", r)
+	}
+	if r := res["web"]; r.Action != config.Failed || r.Err == nil || r.Err.Error() != "signer failed" {
+		t.Errorf("web = %v; want failed with signer failed", r)
+	}
+	if _, err := c.GetBundle("Issuing CA", "web"); err == nil {
+		t.Error("web was issued below a failed signer")
+	}
+}
+
+const reconcileConfig = `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+  - name: web
+    commonName: web.acme.internal
+    signer: Root CA
+    expire: 720h
+    dnsNames: [web.acme.internal]
+`
+
+func TestChanges(t *testing.T) {
+	tests := []struct {
+		desc string
+		name string
+		edit func(*config.Cert)
+		want map[string][]string
+	}{{
+		desc: "unchanged",
+		name: "web",
+		edit: func(*config.Cert) {},
+		want: map[string][]string{},
+	}, {
+		desc: "dnsNames",
+		name: "web",
+		edit: func(c *config.Cert) { c.DNSNames = append(c.DNSNames, "www.acme.internal") },
+		want: map[string][]string{"web": {"dnsNames"}},
+	}, {
+		desc: "subject",
+		name: "web",
+		edit: func(c *config.Cert) { c.Subject.Organization = []string{"Acme Inc."} },
+		want: map[string][]string{"web": {"subject"}},
+	}, {
+		desc: "key",
+		name: "web",
+		edit: func(c *config.Cert) { c.KeyAlgorithm = "ecdsa" },
+		want: map[string][]string{"web": {"key"}},
+	}, {
+		desc: "expire",
+		name: "web",
+		edit: func(c *config.Cert) { c.Expire = config.Duration(2 * 720 * time.Hour) },
+		want: map[string][]string{"web": {"expire"}},
+	}, {
+		// An expire within the minute of tolerance is the same.
+		desc: "expire within tolerance",
+		name: "web",
+		edit: func(c *config.Cert) { c.Expire += config.Duration(30 * time.Second) },
+		want: map[string][]string{},
+	}, {
+		desc: "signer reissued",
+		name: "Root CA",
+		edit: func(c *config.Cert) { c.CommonName = "Acme Root CA" },
+		want: map[string][]string{"Root CA": {"subject"}, "web": {"signer"}},
+	}}
+
+	for _, test := range tests {
+		c := newConfig(t, reconcileConfig)
+		results(t, c)
+		cert, err := c.Store.Get(test.name)
+		if err != nil || cert == nil {
+			t.Fatalf("Get(%v) = %v, %v", test.name, cert, err)
+		}
+		test.edit(cert)
+		if err := c.Store.Update(test.name, *cert); err != nil {
+			t.Fatal(err)
+		}
+
+		plan, err := c.Plan()
+		if err != nil {
+			t.Fatalf("%v: Plan: %v", test.desc, err)
+		}
+		got := map[string][]string{}
+		for _, step := range plan.Steps {
+			if step.Action != config.Unchanged {
+				got[step.Name] = step.Changes
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: changes = %v; want %v", test.desc, got, test.want)
+		}
+	}
+}
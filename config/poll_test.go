@@ -0,0 +1,82 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/google/easypki/pkg/easypki"
+	"github.com/google/easypki/pkg/store"
+)
+
+// Polling is the fallback when the configuration cannot be watched, changes
+// must still be picked up.
+func TestPoll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pki.yml")
+	root := `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+`
+	if err := ioutil.WriteFile(path, []byte(root), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "pki.boltdb"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	y := &Yaml{Path: path}
+	c := &Config{Store: y, EasyPKI: &easypki.EasyPKI{Store: &BoltPKI{Bolt: store.Bolt{DB: db}}}}
+	if _, err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Reloader{Config: c, Source: y, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.poll(ctx, path)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Let the poller take its first fingerprint before changing the file.
+	time.Sleep(50 * time.Millisecond)
+	if !r.Status().Time.IsZero() {
+		t.Fatal("poll reloaded an unchanged configuration")
+	}
+	err = ioutil.WriteFile(path, []byte(root+`  - name: web
+    commonName: web.example.com
+    signer: Root CA
+    expire: 720h
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if cert, err := y.Get("web"); err == nil && cert != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("poll did not reload the changed configuration, last reload: %+v", r.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := r.Status(); !status.OK {
+		t.Errorf("Status() = %+v, want a successful reload", status)
+	}
+	if _, err := c.GetBundle("Root CA", "web"); err != nil {
+		t.Errorf("web was not issued by the reload: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Profile describes a kind of certificate: what its key may be used for, its
+// basic constraints and how long it is valid unless the certificate says
+// otherwise.
+type Profile struct {
+	KeyUsage    []string `yaml:"keyUsage,omitempty" json:"keyUsage,omitempty" toml:"keyUsage,omitempty"`
+	ExtKeyUsage []string `yaml:"extKeyUsage,omitempty" json:"extKeyUsage,omitempty" toml:"extKeyUsage,omitempty"`
+
+	IsCA bool `yaml:"isCA,omitempty" json:"isCA,omitempty" toml:"isCA,omitempty"`
+	// MaxPathLen limits how many intermediate CAs may follow a CA issued
+	// with the profile. Unset leaves it unlimited.
+	MaxPathLen *int `yaml:"maxPathLen,omitempty" json:"maxPathLen,omitempty" toml:"maxPathLen,omitempty"`
+
+	Expire Duration `yaml:"expire,omitempty" json:"expire,omitempty" toml:"expire,omitzero"`
+	// Backdate moves notBefore into the past to tolerate clock skew between
+	// the PKI and relying parties.
+	Backdate Duration `yaml:"backdate,omitempty" json:"backdate,omitempty" toml:"backdate,omitzero"`
+}
+
+// ProfileSource is implemented by stores that define profiles of their own,
+// next to the built-in ones.
+type ProfileSource interface {
+	Profiles() (map[string]Profile, error)
+}
+
+// Profiles used by certificates that do not name one. They issue the same
+// certificates easypki did before profiles existed.
+const (
+	DefaultCAProfile     = "ca"
+	DefaultServerProfile = "server+client"
+	DefaultClientProfile = "tls-client"
+)
+
+const year = Duration(365 * 24 * time.Hour)
+
+// BuiltinProfiles are available to every configuration. A profile defined in
+// the configuration under the same name replaces the built-in one.
+var BuiltinProfiles = map[string]Profile{
+	"ca": {
+		KeyUsage: []string{"digitalSignature", "certSign", "crlSign"},
+		IsCA:     true,
+		Expire:   10 * year,
+	},
+	"tls-server": {
+		KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage: []string{"serverAuth"},
+		Expire:      year,
+	},
+	"tls-client": {
+		KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage: []string{"clientAuth"},
+		Expire:      year,
+	},
+	"server+client": {
+		KeyUsage:    []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage: []string{"serverAuth", "clientAuth"},
+		Expire:      year,
+	},
+	"code-signing": {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"codeSigning"},
+		Expire:      year,
+	},
+	"email-protection": {
+		KeyUsage:    []string{"digitalSignature", "keyEncipherment", "contentCommitment"},
+		ExtKeyUsage: []string{"emailProtection"},
+		Expire:      year,
+	},
+	"ocsp-signing": {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"ocspSigning"},
+		Expire:      year,
+	},
+	"timestamping": {
+		KeyUsage:    []string{"digitalSignature", "contentCommitment"},
+		ExtKeyUsage: []string{"timeStamping"},
+		Expire:      year,
+	},
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"ipsecEndSystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsecTunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecUser":       x509.ExtKeyUsageIPSECUser,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// mergeProfiles returns the built-in profiles overlaid with defined.
+func mergeProfiles(defined map[string]Profile) map[string]Profile {
+	profiles := map[string]Profile{}
+	for name, p := range BuiltinProfiles {
+		profiles[name] = p
+	}
+	for name, p := range defined {
+		profiles[name] = p
+	}
+
+	return profiles
+}
+
+// definedProfiles returns the profiles defined by the store itself, if any.
+func definedProfiles(s Store) (map[string]Profile, error) {
+	ps, ok := s.(ProfileSource)
+	if !ok {
+		return nil, nil
+	}
+	defined, err := ps.Profiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading profiles: %v", err)
+	}
+
+	return defined, nil
+}
+
+// storeProfiles returns every profile available to the store's certificates.
+func storeProfiles(s Store) (map[string]Profile, error) {
+	defined, err := definedProfiles(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeProfiles(defined), nil
+}
+
+// profileName returns the profile cert is issued with.
+func profileName(cert Cert) string {
+	switch {
+	case cert.Profile != "":
+		return cert.Profile
+	case cert.IsCA:
+		return DefaultCAProfile
+	case cert.IsClient:
+		return DefaultClientProfile
+	default:
+		return DefaultServerProfile
+	}
+}
+
+// resolveProfile returns the profile cert is issued with, with the
+// certificate's own settings applied over it.
+func resolveProfile(cert Cert, profiles map[string]Profile) (Profile, error) {
+	name := profileName(cert)
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %v is not defined", name)
+	}
+	if p.IsCA != cert.IsCA {
+		if p.IsCA {
+			return Profile{}, fmt.Errorf("profile %v is for CAs only", name)
+		}
+		return Profile{}, fmt.Errorf("profile %v cannot be used for CAs", name)
+	}
+
+	if len(cert.KeyUsage) > 0 {
+		p.KeyUsage = cert.KeyUsage
+	}
+	if len(cert.ExtKeyUsage) > 0 {
+		p.ExtKeyUsage = cert.ExtKeyUsage
+	}
+	if cert.Expire != 0 {
+		p.Expire = cert.Expire
+	}
+	if cert.Backdate != 0 {
+		p.Backdate = cert.Backdate
+	}
+	if cert.MaxPathLen != nil {
+		p.MaxPathLen = cert.MaxPathLen
+	}
+
+	if _, err := p.keyUsage(); err != nil {
+		return Profile{}, err
+	}
+	if _, err := p.extKeyUsage(); err != nil {
+		return Profile{}, err
+	}
+
+	return p, nil
+}
+
+func (p Profile) keyUsage() (x509.KeyUsage, error) {
+	var usage x509.KeyUsage
+	for _, name := range p.KeyUsage {
+		u, ok := keyUsages[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown key usage %v, expected one of %v", name, knownKeyUsages())
+		}
+		usage |= u
+	}
+
+	return usage, nil
+}
+
+func (p Profile) extKeyUsage() ([]x509.ExtKeyUsage, error) {
+	var usages []x509.ExtKeyUsage
+	for _, name := range p.ExtKeyUsage {
+		u, ok := extKeyUsages[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage %v, expected one of %v", name, knownExtKeyUsages())
+		}
+		usages = append(usages, u)
+	}
+
+	return usages, nil
+}
+
+func knownKeyUsages() []string {
+	var names []string
+	for name := range keyUsages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func knownExtKeyUsages() []string {
+	var names []string
+	for name := range extKeyUsages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Action is what reconciliation did, or would do, for a certificate.
+type Action string
+
+const (
+	Created   Action = "created"
+	Unchanged Action = "unchanged"
+	Reissued  Action = "reissued"
+	Failed    Action = "failed"
+	// Orphaned bundles are issued but no longer part of the configuration.
+	// They are reported, never removed.
+	Orphaned Action = "orphaned"
+	// Requested externally signed CAs have a new key and certificate
+	// request waiting to be signed.
+	Requested Action = "requested"
+	// Pending externally signed CAs wait for their certificate to be
+	// imported, and so do the certificates below them.
+	Pending Action = "pending"
+)
+
+// Result is the outcome of reconciling a single certificate.
+type Result struct {
+	Name   string
+	Signer string
+	Action Action
+	// Changes lists what differed between the definition and the issued
+	// certificate when it was re-issued.
+	Changes []string
+	Err     error
+}
+
+func (r Result) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%v: %v: %v", r.Name, r.Action, r.Err)
+	case len(r.Changes) > 0:
+		return fmt.Sprintf("%v: %v (%v)", r.Name, r.Action, strings.Join(r.Changes, ", "))
+	default:
+		return fmt.Sprintf("%v: %v", r.Name, r.Action)
+	}
+}
+
+// validityTolerance absorbs the time spent between building a template and
+// signing it, and the second precision of certificate timestamps.
+const validityTolerance = time.Minute
+
+// selfSigned reports whether cert is a root signing itself.
+func selfSigned(cert Cert) bool {
+	return cert.Signer == "" || cert.Signer == cert.Name
+}
+
+// issuedCert returns the issued certificate for the definition, or nil if
+// none has been issued yet.
+func (c *Config) issuedCert(cert Cert) *x509.Certificate {
+	ca := cert.Signer
+	if cert.IsCA || selfSigned(cert) {
+		ca = cert.Name
+	}
+
+	return c.fetchCert(ca, cert.Name)
+}
+
+// fetchCert reads a certificate from the easypki store without its key. Any
+// failure to fetch is treated as the certificate not being there; genuine
+// store errors resurface when issuing it.
+func (c *Config) fetchCert(caName, name string) *x509.Certificate {
+	_, raw, err := c.EasyPKI.Store.Fetch(caName, name)
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	crt, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil
+	}
+
+	return crt
+}
+
+// changes compares the definition, issued with profile p by the CA defined
+// as signer, with the issued certificate and returns the names of the fields
+// that differ.
+func (c *Config) changes(cert Cert, signerDef *Cert, p Profile, issued *x509.Certificate) []string {
+	var changes []string
+
+	if issued.Subject.String() != cert.Subject.Name(cert.CommonName).String() {
+		changes = append(changes, "subject")
+	}
+	if !sameSet(issued.DNSNames, cert.DNSNames) {
+		changes = append(changes, "dnsNames")
+	}
+	if !sameSet(issued.EmailAddresses, cert.EmailAddresses) {
+		changes = append(changes, "emailAddresses")
+	}
+	if ips, _ := parseIPs(cert.IPAddresses); !sameSet(ipStrings(issued.IPAddresses), ipStrings(ips)) {
+		changes = append(changes, "ipAddresses")
+	}
+	if uris, _ := parseURIs(cert.URIs); !sameSet(uriStrings(issued.URIs), uriStrings(uris)) {
+		changes = append(changes, "uris")
+	}
+	if issued.IsCA != cert.IsCA {
+		changes = append(changes, "isCA")
+	}
+	if usage, _ := p.keyUsage(); issued.KeyUsage != usage {
+		changes = append(changes, "keyUsage")
+	}
+	if usages, _ := p.extKeyUsage(); !sameExtKeyUsage(issued.ExtKeyUsage, usages) {
+		changes = append(changes, "extKeyUsage")
+	}
+	if cert.CSR != "" {
+		if pub, err := requestKey(cert); err == nil && !samePublicKey(issued.PublicKey, pub) {
+			changes = append(changes, "csr")
+		}
+	} else {
+		alg, size, _ := keyParams(cert)
+		if issuedAlg, issuedSize := PublicKeyParams(issued.PublicKey); issuedAlg != alg || issuedSize != size {
+			changes = append(changes, "key")
+		}
+	}
+	if cert.IsCA {
+		want := &x509.Certificate{}
+		if err := cert.NameConstraints.apply(want); err == nil && !sameNameConstraints(issued, want) {
+			changes = append(changes, "nameConstraints")
+		}
+	}
+
+	if !sameExtensions(issued, cert) {
+		changes = append(changes, "extensions")
+	}
+	if signerDef != nil && !sameURLs(issued, signerDef.URLs, signerDef.Name) {
+		changes = append(changes, "urls")
+	}
+
+	signer := issued
+	if !selfSigned(cert) {
+		signer = c.fetchCert(cert.Signer, cert.Signer)
+	}
+	if signer == nil || issued.CheckSignatureFrom(signer) != nil {
+		changes = append(changes, "signer")
+	}
+	if !selfSigned(cert) && c.revoked(cert.Signer, issued.SerialNumber) {
+		changes = append(changes, "revoked")
+	}
+	parent := signer
+	if selfSigned(cert) {
+		parent = nil
+	}
+
+	if cert.IsCA && issued.MaxPathLen != pathLen(p, parent) {
+		changes = append(changes, "maxPathLen")
+	}
+
+	// Relative windows are anchored at the time the certificate was issued.
+	notBefore, notAfter := window(cert, p, issued.NotBefore.Add(time.Duration(p.Backdate)))
+	if parent != nil {
+		notAfter, _ = clamp(notAfter, parent)
+	}
+	switch {
+	case cert.NotBefore != nil && !within(issued.NotBefore, notBefore):
+		changes = append(changes, "notBefore")
+	case cert.NotAfter != nil && !within(issued.NotAfter, notAfter):
+		changes = append(changes, "notAfter")
+	case cert.NotAfter == nil && !within(issued.NotAfter, notAfter):
+		changes = append(changes, "expire")
+	case time.Now().After(issued.NotAfter):
+		changes = append(changes, "expired")
+	}
+
+	return changes
+}
+
+// within reports whether t is within validityTolerance of want.
+func within(t, want time.Time) bool {
+	d := t.Sub(want)
+	return d <= validityTolerance && d >= -validityTolerance
+}
+
+// sameExtKeyUsage reports whether a and b hold the same usages, ignoring
+// order.
+func sameExtKeyUsage(a, b []x509.ExtKeyUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[x509.ExtKeyUsage]int{}
+	for _, u := range a {
+		count[u]++
+	}
+	for _, u := range b {
+		if count[u] == 0 {
+			return false
+		}
+		count[u]--
+	}
+
+	return true
+}
+
+// sameSet reports whether a and b hold the same values, ignoring order.
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithin(t *testing.T) {
+	want := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{want, true},
+		{want.Add(validityTolerance), true},
+		{want.Add(-validityTolerance), true},
+		{want.Add(validityTolerance + time.Second), false},
+		{want.Add(-validityTolerance - time.Second), false},
+	}
+	for _, test := range tests {
+		if got := within(test.t, want); got != test.want {
+			t.Errorf("within(%v, %v) = %v; want %v", test.t, want, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Reloadable is a Store whose definitions can be re-read from their source
+// and swapped in once checked.
+type Reloadable interface {
+	Store
+	Load() (*Definitions, error)
+	Swap(defs *Definitions)
+}
+
+// Definitions is everything read from a configuration source.
+type Definitions struct {
+	Certs []Cert
+	// Profiles holds the profiles defined by the configuration, without the
+	// built-in ones.
+	Profiles map[string]Profile
+	// Sources lists the files and directories the definitions were read
+	// from, so they can be watched for changes.
+	Sources []string
+}
+
+// ReloadStatus describes the outcome of the last reload.
+type ReloadStatus struct {
+	Time    time.Time      `json:"time"`
+	OK      bool           `json:"ok"`
+	Error   string         `json:"error,omitempty"`
+	Actions map[Action]int `json:"actions,omitempty"`
+	Failed  []string       `json:"failed,omitempty"`
+}
+
+// Reloader re-reads the configuration when it changes. A new configuration
+// is validated and reconciled before it replaces the one being served; an
+// invalid one is rejected and the previous configuration stays in place.
+type Reloader struct {
+	Config *Config
+	Source Reloadable
+	// Interval is how often the file is polled when it cannot be watched.
+	Interval time.Duration
+
+	mu     sync.Mutex
+	status ReloadStatus
+	// sources are the files and directories of the last configuration
+	// loaded.
+	sources []string
+}
+
+// Reload loads, validates and reconciles the configuration, then swaps it in.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := ReloadStatus{Time: time.Now()}
+	err := r.reload(&status)
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Configuration reload failed, keeping the previous configuration: %v", err)
+	} else {
+		status.OK = true
+		log.Printf("Configuration reloaded: %v", status.Actions)
+	}
+	r.status = status
+
+	return err
+}
+
+func (r *Reloader) reload(status *ReloadStatus) error {
+	defs, err := r.Source.Load()
+	if err != nil {
+		return err
+	}
+	r.sources = defs.Sources
+	profiles := mergeProfiles(defs.Profiles)
+	if errs := validateCerts(defs.Certs, profiles); len(errs) > 0 {
+		return errs
+	}
+
+	r.Config.issuing.Lock()
+	defer r.Config.issuing.Unlock()
+	plan, err := r.Config.planTree(buildTree(defs.Certs), profiles)
+	if err != nil {
+		return err
+	}
+	status.Actions = map[Action]int{}
+	for _, res := range r.Config.Apply(plan) {
+		status.Actions[res.Action]++
+		if res.Action != Unchanged {
+			log.Println(res)
+		}
+		if res.Err != nil {
+			status.Failed = append(status.Failed, res.String())
+		}
+	}
+
+	r.Source.Swap(defs)
+	r.Config.resetOCSP()
+
+	return nil
+}
+
+// Status returns the outcome of the last reload.
+func (r *Reloader) Status() ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// Watch reloads whenever the configuration at path, or a file it includes,
+// changes, until ctx is done. It watches the directories holding them so
+// editors and atomic writes replacing files are noticed, and falls back to
+// polling when watching is unavailable.
+func (r *Reloader) Watch(ctx context.Context, path string) {
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = r.watchSources(w, path)
+	}
+	if err != nil {
+		log.Printf("Cannot watch %v, polling it instead: %v", path, err)
+		if w != nil {
+			w.Close()
+		}
+		r.poll(ctx, path)
+		return
+	}
+	defer w.Close()
+
+	// Editors tend to write files in several steps, wait for them to settle.
+	const settle = 500 * time.Millisecond
+	timer := time.NewTimer(settle)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-w.Events:
+			if r.relevant(path, ev.Name) {
+				timer.Reset(settle)
+			}
+		case err := <-w.Errors:
+			log.Printf("Error watching %v: %v", path, err)
+		case <-timer.C:
+			r.Reload()
+			// Includes may have changed, watch whatever was added.
+			if err := r.watchSources(w, path); err != nil {
+				log.Printf("Error watching %v: %v", path, err)
+			}
+		}
+	}
+}
+
+// watched returns the files and directories the configuration at path was
+// last read from.
+func (r *Reloader) watched(path string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.sources) == 0 {
+		return []string{path}
+	}
+
+	return r.sources
+}
+
+// watchDirs returns the configuration directories and the directories
+// holding the configuration files.
+func (r *Reloader) watchDirs(path string) []string {
+	var dirs []string
+	for _, src := range r.watched(path) {
+		dir := src
+		if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+			dir = filepath.Dir(src)
+		}
+		if !contains(dirs, filepath.Clean(dir)) {
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+
+	return dirs
+}
+
+func (r *Reloader) watchSources(w *fsnotify.Watcher, path string) error {
+	for _, dir := range r.watchDirs(path) {
+		if err := w.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relevant reports whether a change to the file name may affect the
+// configuration: it is one of its files, or a fragment next to them that a
+// directory or include glob may pick up.
+func (r *Reloader) relevant(path, name string) bool {
+	name = filepath.Clean(name)
+	for _, src := range r.watched(path) {
+		if name == filepath.Clean(src) {
+			return true
+		}
+	}
+	if strings.HasPrefix(filepath.Base(name), ".") || !configExtension(name) {
+		return false
+	}
+
+	return contains(r.watchDirs(path), filepath.Dir(name))
+}
+
+func (r *Reloader) poll(ctx context.Context, path string) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := r.fingerprint(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fp := r.fingerprint(path)
+			if fp != last {
+				r.Reload()
+			}
+			last = fp
+		}
+	}
+}
+
+// fingerprint summarizes the modification times and sizes of the
+// configuration files and of the fragments next to them.
+func (r *Reloader) fingerprint(path string) string {
+	var b strings.Builder
+	stat := func(name string) {
+		if fi, err := os.Stat(name); err == nil {
+			fmt.Fprintf(&b, "%v %v %d\n", name, fi.ModTime().UnixNano(), fi.Size())
+		}
+	}
+	for _, src := range r.watched(path) {
+		stat(src)
+	}
+	for _, dir := range r.watchDirs(path) {
+		files, _ := fragments(dir)
+		for _, f := range files {
+			stat(f)
+		}
+	}
+
+	return b.String()
+}
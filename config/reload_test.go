@@ -0,0 +1,89 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"easypki-ui/config"
+)
+
+const reloadYaml = `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+  - name: web
+    commonName: web.example.com
+    signer: Root CA
+    expire: 720h
+`
+
+func TestReload(t *testing.T) {
+	c := newConfig(t, reloadYaml)
+	results(t, c)
+	y := c.Store.(*config.Yaml)
+	r := &config.Reloader{Config: c, Source: y}
+
+	rewrite := func(data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(y.Path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite(reloadYaml + `  - name: api
+    commonName: api.example.com
+    signer: Root CA
+    expire: 720h
+`)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	status := r.Status()
+	if !status.OK || status.Error != "" || status.Time.IsZero() {
+		t.Errorf("Status() = %+v, want a successful reload", status)
+	}
+	if status.Actions[config.Created] != 1 || status.Actions[config.Unchanged] != 2 {
+		t.Errorf("Status().Actions = %v, want api created and the rest unchanged", status.Actions)
+	}
+	if cert, err := c.Store.Get("api"); err != nil || cert == nil {
+		t.Fatalf("Get(api) after reload = %v, %v", cert, err)
+	}
+	if _, err := c.GetBundle("Root CA", "api"); err != nil {
+		t.Errorf("api was not issued by the reload: %v", err)
+	}
+
+	// A signer that is not defined fails validation, the previous
+	// configuration must stay in place.
+	rewrite(reloadYaml + `  - name: mail
+    commonName: mail.example.com
+    signer: Missing CA
+    expire: 720h
+`)
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload() accepted a certificate signed by an undefined CA")
+	}
+	status = r.Status()
+	if status.OK || !strings.Contains(status.Error, "Missing CA") {
+		t.Errorf("Status() = %+v, want the validation failure", status)
+	}
+	if cert, err := c.Store.Get("api"); err != nil || cert == nil {
+		t.Errorf("Get(api) after a rejected reload = %v, %v; want the previous definition", cert, err)
+	}
+	if cert, err := c.Store.Get("mail"); err != nil || cert != nil {
+		t.Errorf("Get(mail) after a rejected reload = %v, %v; want nothing", cert, err)
+	}
+
+	// Broken YAML is rejected the same way.
+	rewrite("certs: [")
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload() accepted a file that does not parse")
+	}
+	if status := r.Status(); status.OK || status.Error == "" {
+		t.Errorf("Status() = %+v, want the parse failure", status)
+	}
+	if certs, err := c.Store.List(config.Filter{}); err != nil || len(certs) != 3 {
+		t.Errorf("List() after a rejected reload = %d certificates, %v; want 3", len(certs), err)
+	}
+}
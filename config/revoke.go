@@ -0,0 +1,251 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// RevocationStore is implemented by easypki stores able to keep the reasons
+// certificates were revoked for, which easypki does not record, the last CRL
+// each CA signed and their delegated OCSP signers. CRLs and OCSP responses
+// can only be served from such stores.
+type RevocationStore interface {
+	// PutReason records the RFC 5280 reason code the certificate serial of
+	// the CA caName was revoked for.
+	PutReason(caName string, serial *big.Int, reason int) error
+	// Reasons returns the reason codes recorded for caName by serial, in
+	// decimal.
+	Reasons(caName string) (map[string]int, error)
+	// PutCRL replaces the DER CRL of caName.
+	PutCRL(caName string, crl []byte) error
+	// CRL returns the DER CRL of caName, or nil if none was signed yet.
+	CRL(caName string) ([]byte, error)
+	// PutResponder replaces the DER key and certificate of the delegated
+	// OCSP signer of caName.
+	PutResponder(caName string, key, cert []byte) error
+	// Responder returns the delegated OCSP signer of caName, both nil if
+	// there is none.
+	Responder(caName string) (key, cert []byte, err error)
+	// PutSuperseded records that the certificate serial of caName, valid
+	// until notAfter, was replaced by a reissue without being revoked.
+	PutSuperseded(caName string, serial *big.Int, notAfter time.Time) error
+	// Superseded returns when the superseded certificates of caName expire
+	// by serial, in decimal.
+	Superseded(caName string) (map[string]time.Time, error)
+}
+
+// ErrRevoked is returned when revoking a certificate that already is.
+var ErrRevoked = errors.New("certificate is already revoked")
+
+// defaultCRLExpire is how long CRLs are valid when their CA sets no
+// crlExpire.
+const defaultCRLExpire = 24 * time.Hour
+
+// revocationReasons maps the RFC 5280 reason names revocations are requested
+// with to their codes. Revocations are final, so certificateHold and
+// removeFromCRL are left out.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+func (c *Config) revocationStore() (RevocationStore, error) {
+	s, ok := c.EasyPKI.Store.(RevocationStore)
+	if !ok {
+		return nil, errors.New("the easypki store cannot hold revocations")
+	}
+
+	return s, nil
+}
+
+// Revoke revokes the certificate name signed by the CA caName for reason,
+// an RFC 5280 reason name such as "keyCompromise"; empty is "unspecified".
+// The revocation is recorded in the easypki store and the CA's next CRL
+// lists it. The definition is kept but not reissued: reconciling plans it,
+// and what it signs, as pending until Reissue replaces it or the definition
+// changes.
+func (c *Config) Revoke(caName, name, reason string) error {
+	c.issuing.Lock()
+	defer c.issuing.Unlock()
+	defer c.resetOCSP()
+
+	if reason == "" {
+		reason = "unspecified"
+	}
+	code, ok := revocationReasons[reason]
+	if !ok {
+		return fmt.Errorf("unknown revocation reason %v", reason)
+	}
+	if caName == name {
+		return errors.New("a CA cannot revoke its own certificate")
+	}
+	s, err := c.revocationStore()
+	if err != nil {
+		return err
+	}
+
+	ca, err := c.GetCA(caName)
+	if err != nil {
+		return ErrNotFound
+	}
+	bundle, err := c.GetBundle(caName, name)
+	if err != nil {
+		return ErrNotFound
+	}
+	if err := bundle.Cert.CheckSignatureFrom(ca.Cert); err != nil {
+		return fmt.Errorf("%v is not signed by the current certificate of %v: %v", name, caName, err)
+	}
+	if c.revoked(caName, bundle.Cert.SerialNumber) {
+		return ErrRevoked
+	}
+
+	if err := s.PutReason(caName, bundle.Cert.SerialNumber, code); err != nil {
+		return fmt.Errorf("failed saving revocation reason of %v: %v", name, err)
+	}
+	if err := c.EasyPKI.Revoke(caName, bundle.Cert); err != nil {
+		return fmt.Errorf("failed revoking %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// Reissue issues the certificate name anew from its definition, with a new
+// key, typically after it was revoked. Certificates issued for a
+// certificate request cannot be, a new request replaces them; the
+// certificates below a reissued CA are reissued on the next reconciliation.
+func (c *Config) Reissue(name string) error {
+	c.issuing.Lock()
+	defer c.issuing.Unlock()
+
+	plan, err := c.Plan()
+	if err != nil {
+		return err
+	}
+	for _, step := range plan.Steps {
+		if step.Name != name || step.Action == Orphaned {
+			continue
+		}
+		switch {
+		case step.Action == Failed:
+			return errors.New(step.Error)
+		case step.cert.CSR != "":
+			return errors.New("certificates issued for a request are replaced by signing a new request")
+		case step.cert.External || step.cert.Imported:
+			return errors.New("externally signed and imported CAs cannot be reissued")
+		case step.Action == Pending && !contains(step.Changes, "revoked"):
+			return fmt.Errorf("cannot reissue %v until its signer is", name)
+		}
+		return c.makeCert(step.cert, step.signer, step.profile, step.chain)
+	}
+
+	return ErrNotFound
+}
+
+// revoked reports whether the certificate serial signed by caName was
+// revoked. Failing to read the revocations counts as not revoked.
+func (c *Config) revoked(caName string, serial *big.Int) bool {
+	revoked, err := c.EasyPKI.Store.Revoked(caName)
+	if err != nil {
+		return false
+	}
+	for _, r := range revoked {
+		if r.SerialNumber.Cmp(serial) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CRL returns the DER CRL of the CA caName. The last one signed is reused
+// until half of its validity has passed, a certificate is revoked or the CA
+// is reissued; a new one is signed and saved otherwise.
+func (c *Config) CRL(caName string) ([]byte, error) {
+	// Concurrent requests would otherwise sign CRLs with the same number.
+	c.signing.Lock()
+	defer c.signing.Unlock()
+
+	def, err := c.Store.Get(caName)
+	if err != nil {
+		return nil, err
+	}
+	if def == nil || !def.IsCA {
+		return nil, ErrNotFound
+	}
+	s, err := c.revocationStore()
+	if err != nil {
+		return nil, err
+	}
+	ca, err := c.GetCA(caName)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	revoked, err := c.EasyPKI.Store.Revoked(caName)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching revocations of %v: %v", caName, err)
+	}
+	expire := time.Duration(def.CRLExpire)
+	if expire == 0 {
+		expire = defaultCRLExpire
+	}
+
+	raw, err := s.CRL(caName)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching CRL of %v: %v", caName, err)
+	}
+	number := big.NewInt(1)
+	if raw != nil {
+		if last, err := x509.ParseRevocationList(raw); err == nil {
+			if last.CheckSignatureFrom(ca.Cert) == nil &&
+				len(last.RevokedCertificateEntries) == len(revoked) &&
+				last.NextUpdate.Sub(last.ThisUpdate) == expire &&
+				time.Now().Before(last.ThisUpdate.Add(expire/2)) {
+				return raw, nil
+			}
+			number.Add(last.Number, number)
+		}
+	}
+
+	reasons, err := s.Reasons(caName)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching revocation reasons of %v: %v", caName, err)
+	}
+	var entries []x509.RevocationListEntry
+	for _, r := range revoked {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   r.SerialNumber,
+			RevocationTime: r.RevocationTime,
+			ReasonCode:     reasons[r.SerialNumber.String()],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RevocationTime.Before(entries[j].RevocationTime)
+	})
+
+	now := time.Now()
+	raw, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(expire),
+	}, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed signing CRL of %v: %v", caName, err)
+	}
+	if err := s.PutCRL(caName, raw); err != nil {
+		return nil, fmt.Errorf("failed saving CRL of %v: %v", caName, err)
+	}
+
+	return raw, nil
+}
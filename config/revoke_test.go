@@ -0,0 +1,245 @@
+package config_test
+
+import (
+	"crypto/x509"
+	"reflect"
+	"testing"
+	"time"
+
+	"easypki-ui/config"
+)
+
+const revokeConfig = `certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+    crlExpire: 48h
+  - name: Issuing CA
+    commonName: Issuing CA
+    signer: Root CA
+    isCA: true
+    expire: 4380h
+  - name: web
+    commonName: web.acme.internal
+    signer: Root CA
+    expire: 720h
+  - name: api
+    commonName: api.acme.internal
+    signer: Root CA
+    expire: 720h
+  - name: mail
+    commonName: mail.acme.internal
+    signer: Issuing CA
+    expire: 720h
+`
+
+func serial(t *testing.T, c *config.Config, caName, name string) string {
+	t.Helper()
+	bundle, err := c.GetBundle(caName, name)
+	if err != nil {
+		t.Fatalf("GetBundle(%v, %v): %v", caName, name, err)
+	}
+
+	return bundle.Cert.SerialNumber.String()
+}
+
+func crl(t *testing.T, c *config.Config, caName string) ([]byte, *x509.RevocationList) {
+	t.Helper()
+	raw, err := c.CRL(caName)
+	if err != nil {
+		t.Fatalf("CRL(%v): %v", caName, err)
+	}
+	list, err := x509.ParseRevocationList(raw)
+	if err != nil {
+		t.Fatalf("failed parsing CRL of %v: %v", caName, err)
+	}
+	ca, err := c.GetCA(caName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := list.CheckSignatureFrom(ca.Cert); err != nil {
+		t.Errorf("CRL of %v is not signed by it: %v", caName, err)
+	}
+
+	return raw, list
+}
+
+// entries maps the serials a CRL lists to their reason codes.
+func entries(list *x509.RevocationList) map[string]int {
+	got := map[string]int{}
+	for _, e := range list.RevokedCertificateEntries {
+		got[e.SerialNumber.String()] = e.ReasonCode
+	}
+
+	return got
+}
+
+func TestCRL(t *testing.T) {
+	c := newConfig(t, revokeConfig)
+	results(t, c)
+	web, api := serial(t, c, "Root CA", "web"), serial(t, c, "Root CA", "api")
+
+	raw, list := crl(t, c, "Root CA")
+	if len(list.RevokedCertificateEntries) != 0 || list.Number.Int64() != 1 {
+		t.Errorf("first CRL has %d entries and number %v; want none and 1", len(list.RevokedCertificateEntries), list.Number)
+	}
+	if d := list.NextUpdate.Sub(list.ThisUpdate); d != 48*time.Hour {
+		t.Errorf("CRL is valid for %v; want the crlExpire 48h", d)
+	}
+
+	if err := c.Revoke("Root CA", "web", "keyCompromise"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	raw, list = crl(t, c, "Root CA")
+	if want := map[string]int{web: 1}; !reflect.DeepEqual(entries(list), want) || list.Number.Int64() != 2 {
+		t.Errorf("CRL after revoking web lists %v with number %v; want %v and 2", entries(list), list.Number, want)
+	}
+	if again, _ := crl(t, c, "Root CA"); string(again) != string(raw) {
+		t.Error("an unchanged CRL was signed again")
+	}
+
+	if err := c.Revoke("Root CA", "api", ""); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	_, list = crl(t, c, "Root CA")
+	if want := map[string]int{web: 1, api: 0}; !reflect.DeepEqual(entries(list), want) || list.Number.Int64() != 3 {
+		t.Errorf("CRL after revoking api lists %v with number %v; want %v and 3", entries(list), list.Number, want)
+	}
+
+	// Concurrent requests get the one CRL the first of them signed.
+	if err := c.Revoke("Root CA", "Issuing CA", ""); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	lists := make(chan []byte)
+	for i := 0; i < 8; i++ {
+		go func() {
+			raw, err := c.CRL("Root CA")
+			if err != nil {
+				t.Errorf("CRL: %v", err)
+			}
+			lists <- raw
+		}()
+	}
+	first := <-lists
+	for i := 1; i < 8; i++ {
+		if raw := <-lists; string(raw) != string(first) {
+			t.Error("concurrent requests signed more than one CRL")
+		}
+	}
+	if _, list := crl(t, c, "Root CA"); list.Number.Int64() != 4 {
+		t.Errorf("CRL after revoking Issuing CA has number %v; want 4", list.Number)
+	}
+
+	if _, list := crl(t, c, "Issuing CA"); len(list.RevokedCertificateEntries) != 0 {
+		t.Errorf("CRL of Issuing CA lists %v; want none", entries(list))
+	}
+	if _, err := c.CRL("web"); err != config.ErrNotFound {
+		t.Errorf("CRL(web) = %v; want ErrNotFound", err)
+	}
+}
+
+func TestRevokeErrors(t *testing.T) {
+	c := newConfig(t, revokeConfig)
+	results(t, c)
+	if err := c.Revoke("Root CA", "web", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc          string
+		ca, name, why string
+		want          error
+	}{
+		{"again", "Root CA", "web", "", config.ErrRevoked},
+		{"undefined", "Root CA", "ftp", "", config.ErrNotFound},
+		{"other signer", "Issuing CA", "api", "", config.ErrNotFound},
+	}
+	for _, test := range tests {
+		if err := c.Revoke(test.ca, test.name, test.why); err != test.want {
+			t.Errorf("%v: Revoke = %v; want %v", test.desc, err, test.want)
+		}
+	}
+	for _, test := range []struct{ ca, name, why string }{
+		{"Root CA", "Root CA", ""},
+		{"Root CA", "api", "certificateHold"},
+	} {
+		if err := c.Revoke(test.ca, test.name, test.why); err == nil {
+			t.Errorf("Revoke(%v, %v, %v) succeeded", test.ca, test.name, test.why)
+		}
+	}
+}
+
+func actions(t *testing.T, c *config.Config) map[string]config.Action {
+	t.Helper()
+	plan, err := c.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]config.Action{}
+	for _, step := range plan.Steps {
+		if step.Action != config.Unchanged {
+			got[step.Name] = step.Action
+		}
+	}
+
+	return got
+}
+
+// Revoked certificates stay revoked until they are reissued explicitly.
+func TestRevokeReissue(t *testing.T) {
+	c := newConfig(t, revokeConfig)
+	results(t, c)
+	web := serial(t, c, "Root CA", "web")
+
+	if err := c.Revoke("Root CA", "web", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actions(t, c), map[string]config.Action{"web": config.Pending}; !reflect.DeepEqual(got, want) {
+		t.Errorf("plan after revoking web = %v; want %v", got, want)
+	}
+	results(t, c)
+	if serial(t, c, "Root CA", "web") != web {
+		t.Error("reconciling reissued the revoked web")
+	}
+
+	if err := c.Reissue("web"); err != nil {
+		t.Fatalf("Reissue: %v", err)
+	}
+	if serial(t, c, "Root CA", "web") == web {
+		t.Error("Reissue kept the revoked certificate")
+	}
+	if got := actions(t, c); len(got) != 0 {
+		t.Errorf("plan after reissuing web = %v; want nothing to do", got)
+	}
+	if err := c.Reissue("ftp"); err != config.ErrNotFound {
+		t.Errorf("Reissue(ftp) = %v; want ErrNotFound", err)
+	}
+}
+
+// What a revoked CA signed is pending until the CA is reissued, and then
+// reissued by it.
+func TestRevokeCA(t *testing.T) {
+	c := newConfig(t, revokeConfig)
+	results(t, c)
+	mail := serial(t, c, "Issuing CA", "mail")
+
+	if err := c.Revoke("Root CA", "Issuing CA", "cACompromise"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]config.Action{"Issuing CA": config.Pending, "mail": config.Pending}
+	if got := actions(t, c); !reflect.DeepEqual(got, want) {
+		t.Errorf("plan after revoking Issuing CA = %v; want %v", got, want)
+	}
+	if err := c.Reissue("mail"); err == nil {
+		t.Error("Reissue of mail below the revoked Issuing CA succeeded")
+	}
+
+	if err := c.Reissue("Issuing CA"); err != nil {
+		t.Fatalf("Reissue: %v", err)
+	}
+	res := results(t, c)
+	if res["mail"].Action != config.Reissued || serial(t, c, "Issuing CA", "mail") == mail {
+		t.Errorf("mail = %v after reissuing Issuing CA; want reissued", res["mail"])
+	}
+}
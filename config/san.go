@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// parseIPs parses the ipAddresses of a certificate.
+func parseIPs(values []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, v := range values {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", v)
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, nil
+}
+
+// parseURIs parses the uris of a certificate. URIs must be absolute, and
+// SPIFFE IDs must follow the SPIFFE ID format: a trust domain and no port,
+// user info, query or fragment.
+func parseURIs(values []string) ([]*url.URL, error) {
+	var uris []*url.URL
+	for _, v := range values {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URI %q: %v", v, err)
+		}
+		if !u.IsAbs() {
+			return nil, fmt.Errorf("invalid URI %q: not absolute", v)
+		}
+		if u.Scheme == "spiffe" {
+			switch {
+			case u.Host == "":
+				return nil, fmt.Errorf("invalid SPIFFE ID %q: no trust domain", v)
+			case u.Port() != "" || u.User != nil:
+				return nil, fmt.Errorf("invalid SPIFFE ID %q: trust domain cannot have a port or user info", v)
+			case u.RawQuery != "" || u.Fragment != "":
+				return nil, fmt.Errorf("invalid SPIFFE ID %q: cannot have a query or fragment", v)
+			}
+		}
+		uris = append(uris, u)
+	}
+
+	return uris, nil
+}
+
+// ipStrings returns ips in their canonical text form.
+func ipStrings(ips []net.IP) []string {
+	var values []string
+	for _, ip := range ips {
+		values = append(values, ip.String())
+	}
+
+	return values
+}
+
+// uriStrings returns uris in their text form.
+func uriStrings(uris []*url.URL) []string {
+	var values []string
+	for _, u := range uris {
+		values = append(values, u.String())
+	}
+
+	return values
+}
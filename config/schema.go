@@ -0,0 +1,157 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaEnums restricts properties, or the items of list properties, to the
+// values the configuration accepts.
+var schemaEnums = map[string]func() []string{
+	"keyAlgorithm": func() []string { return []string{RSA, ECDSA, Ed25519} },
+	"keyUsage":     knownKeyUsages,
+	"extKeyUsage":  knownExtKeyUsages,
+}
+
+// schemaDescriptions documents properties for editors.
+var schemaDescriptions = map[string]string{
+	"name":            "Unique name of the certificate, used to refer to it as a signer.",
+	"subject":         "Distinguished name fields besides the common name.",
+	"inheritSubject":  "Take the subject fields left unset from the signer.",
+	"commonName":      "Common name; may be a template such as {{.Name}}.",
+	"signer":          "Name of the signing CA. Empty or the certificate's own name for self signed roots.",
+	"expire":          "Validity period, such as 8760h.",
+	"notBefore":       "Absolute start of the validity period.",
+	"notAfter":        "Absolute end of the validity period, instead of expire.",
+	"backdate":        "How far notBefore is moved into the past.",
+	"isCA":            "Whether the certificate is a CA.",
+	"isClient":        "Issue a client certificate by default.",
+	"maxPathLen":      "How many CAs may follow this one; 0 only signs end entities.",
+	"external":        "The CA is signed outside of the PKI: a key and certificate request are generated and the signed certificate imported.",
+	"imported":        "The CA's certificate and key are generated elsewhere and imported; it is never issued.",
+	"nameConstraints": "Names the CA and the CAs below it may issue certificates for.",
+	"urls":            "Public URLs of a CA, stamped into the certificates it signs as CRL distribution points, OCSP servers and issuing certificate URLs.",
+	"crlExpire":       "How long the CRLs the CA signs are valid, 24h by default.",
+	"delegatedOCSP":   "Sign the CA's OCSP responses with a delegated OCSP signing certificate instead of the CA key.",
+	"signProfiles":    "Profiles certificate requests signed by the CA through the API may ask for, the first being the default.",
+	"policies":        "Certificate policies, by OID with optional CPS URIs and user notice.",
+	"extensions":      "Further extensions by OID, with a hex DER or a UTF8String value.",
+	"csr":             "PEM certificate request the certificate is issued for; the requester keeps the key.",
+	"profile":         "Profile to issue the certificate with.",
+	"keyAlgorithm":    "Key algorithm, rsa by default.",
+	"keySize":         "RSA modulus or ECDSA curve size.",
+}
+
+// durationPattern matches the durations time.ParseDuration accepts.
+const durationPattern = `^[-+]?(0|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$`
+
+// Schema returns a JSON Schema of configuration files, generated from Cert
+// and Profile. It applies to YAML, JSON and TOML files alike.
+func Schema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "easypki-ui configuration",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"$schema": map[string]interface{}{"type": "string"},
+			"include": map[string]interface{}{
+				"description": "Globs of further configuration files, relative to this one.",
+				"oneOf": []interface{}{
+					map[string]interface{}{"type": "string"},
+					map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				},
+			},
+			"vars": map[string]interface{}{
+				"description":   "Default values of the variables referred to as ${NAME}; the environment overrides them.",
+				"type":          "object",
+				"propertyNames": map[string]interface{}{"pattern": varName.String()},
+				"additionalProperties": map[string]interface{}{
+					"type": []string{"string", "number", "boolean"},
+				},
+			},
+			"profiles": map[string]interface{}{
+				"description":          "Profiles by name, replacing built-in profiles of the same name.",
+				"type":                 "object",
+				"additionalProperties": typeSchema(reflect.TypeOf(Profile{})),
+			},
+			"certs": map[string]interface{}{
+				"description": "Certificate definitions.",
+				"type":        "array",
+				"items":       typeSchema(reflect.TypeOf(Cert{})),
+			},
+		},
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return map[string]interface{}{"type": "string", "pattern": durationPattern}
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts := tagName(field.Tag.Get("yaml"))
+			if name == "-" || field.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+
+			s := typeSchema(field.Type)
+			if enum, ok := schemaEnums[name]; ok {
+				if items, ok := s["items"].(map[string]interface{}); ok {
+					items["enum"] = enum()
+				} else {
+					s["enum"] = enum()
+				}
+			}
+			if d, ok := schemaDescriptions[name]; ok && t == reflect.TypeOf(Cert{}) {
+				s["description"] = d
+			}
+			properties[name] = s
+		}
+
+		s := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// tagName splits a struct tag value into its name and options.
+func tagName(tag string) (string, string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+
+	return tag, ""
+}
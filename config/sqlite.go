@@ -0,0 +1,769 @@
+package config
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order, each exactly once. Append new
+// migrations; never edit one that has been released.
+var sqliteMigrations = []string{
+	`CREATE TABLE certs (
+		name        TEXT PRIMARY KEY,
+		common_name TEXT NOT NULL DEFAULT '',
+		signer      TEXT NOT NULL DEFAULT '',
+		expire      INTEGER NOT NULL DEFAULT 0,
+		is_ca       BOOLEAN NOT NULL DEFAULT 0,
+		is_client   BOOLEAN NOT NULL DEFAULT 0
+	);
+	CREATE INDEX certs_signer ON certs (signer);
+	CREATE TABLE sans (
+		cert     TEXT NOT NULL REFERENCES certs (name),
+		kind     TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		value    TEXT NOT NULL,
+		PRIMARY KEY (cert, kind, position)
+	);
+	CREATE TABLE subjects (
+		cert     TEXT NOT NULL REFERENCES certs (name),
+		field    TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		value    TEXT NOT NULL,
+		PRIMARY KEY (cert, field, position)
+	);
+	CREATE TABLE meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+	`ALTER TABLE certs ADD COLUMN profile TEXT NOT NULL DEFAULT '';
+	CREATE TABLE usages (
+		cert     TEXT NOT NULL REFERENCES certs (name),
+		kind     TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		value    TEXT NOT NULL,
+		PRIMARY KEY (cert, kind, position)
+	);
+	CREATE TABLE profiles (
+		name       TEXT PRIMARY KEY,
+		definition TEXT NOT NULL
+	);`,
+	`ALTER TABLE certs ADD COLUMN key_algorithm TEXT NOT NULL DEFAULT '';
+	ALTER TABLE certs ADD COLUMN key_size INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE certs ADD COLUMN name_constraints BOOLEAN NOT NULL DEFAULT 0;
+	ALTER TABLE certs ADD COLUMN name_constraints_critical BOOLEAN NOT NULL DEFAULT 0;
+	CREATE TABLE name_constraints (
+		cert     TEXT NOT NULL REFERENCES certs (name),
+		kind     TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		value    TEXT NOT NULL,
+		PRIMARY KEY (cert, kind, position)
+	);`,
+	`ALTER TABLE certs ADD COLUMN max_path_len INTEGER;
+	ALTER TABLE certs ADD COLUMN not_before TEXT;
+	ALTER TABLE certs ADD COLUMN not_after TEXT;
+	ALTER TABLE certs ADD COLUMN backdate INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE certs ADD COLUMN inherit_subject BOOLEAN NOT NULL DEFAULT 0;`,
+	`CREATE TABLE urls (
+		cert     TEXT NOT NULL REFERENCES certs (name),
+		kind     TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		value    TEXT NOT NULL,
+		PRIMARY KEY (cert, kind, position)
+	);`,
+	`ALTER TABLE certs ADD COLUMN policies TEXT NOT NULL DEFAULT '';
+	ALTER TABLE certs ADD COLUMN extensions TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE certs ADD COLUMN external BOOLEAN NOT NULL DEFAULT 0;`,
+	`ALTER TABLE certs ADD COLUMN imported BOOLEAN NOT NULL DEFAULT 0;`,
+	`ALTER TABLE certs ADD COLUMN csr TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE certs ADD COLUMN crl_expire INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE certs ADD COLUMN delegated_ocsp BOOLEAN NOT NULL DEFAULT 0;`,
+	`ALTER TABLE certs ADD COLUMN sign_profiles TEXT NOT NULL DEFAULT '';`,
+}
+
+// SAN kinds in the sans table.
+const (
+	sanDNS   = "dns"
+	sanEmail = "email"
+	sanIP    = "ip"
+	sanURI   = "uri"
+)
+
+// Usage kinds in the usages table.
+const (
+	usageKey    = "key"
+	usageExtKey = "extKey"
+)
+
+// SQLite is a Store keeping certificate definitions in a SQLite database,
+// with the signer indexed so trees and listings of large PKIs do not have to
+// load every definition.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens, and creates or migrates if needed, the SQLite database at
+// path.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening sqlite database %v: %v", path, err)
+	}
+	// SQLite allows a single writer; serialising through one connection
+	// avoids SQLITE_BUSY errors between our own transactions.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed migrating sqlite database %v: %v", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies every migration newer than the database's user_version.
+func (s *SQLite) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed reading schema version: %v", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than supported version %d", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		err := s.tx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+				return err
+			}
+			// PRAGMA does not take bind parameters.
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed applying migration %d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLite) tx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// subjectFields maps the subjects table field column to the Subject fields.
+func subjectFields(s *Subject) map[string]*[]string {
+	return map[string]*[]string{
+		"country":            &s.Country,
+		"organization":       &s.Organization,
+		"organizationalUnit": &s.OrganizationalUnit,
+		"locality":           &s.Locality,
+		"province":           &s.Province,
+		"streetAddress":      &s.StreetAddress,
+		"postalCode":         &s.PostalCode,
+	}
+}
+
+func sanFields(c *Cert) map[string]*[]string {
+	return map[string]*[]string{
+		sanDNS:   &c.DNSNames,
+		sanEmail: &c.EmailAddresses,
+		sanIP:    &c.IPAddresses,
+		sanURI:   &c.URIs,
+	}
+}
+
+func usageFields(c *Cert) map[string]*[]string {
+	return map[string]*[]string{
+		usageKey:    &c.KeyUsage,
+		usageExtKey: &c.ExtKeyUsage,
+	}
+}
+
+// constraintFields maps the name_constraints table kind column to the
+// NameConstraints fields.
+func constraintFields(nc *NameConstraints) map[string]*[]string {
+	return map[string]*[]string{
+		"permittedDNS":   &nc.Permitted.DNSDomains,
+		"permittedEmail": &nc.Permitted.EmailAddresses,
+		"permittedIP":    &nc.Permitted.IPRanges,
+		"permittedURI":   &nc.Permitted.URIDomains,
+		"excludedDNS":    &nc.Excluded.DNSDomains,
+		"excludedEmail":  &nc.Excluded.EmailAddresses,
+		"excludedIP":     &nc.Excluded.IPRanges,
+		"excludedURI":    &nc.Excluded.URIDomains,
+	}
+}
+
+// urlFields maps the urls table kind column to the URLs fields.
+func urlFields(u *URLs) map[string]*[]string {
+	return map[string]*[]string{
+		"base":   &u.Base,
+		"crl":    &u.CRL,
+		"ocsp":   &u.OCSP,
+		"issuer": &u.Issuer,
+	}
+}
+
+// jsonColumn stores a list as JSON text, empty when there is nothing to
+// store.
+func jsonColumn(v interface{}, empty bool) (string, error) {
+	if empty {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+
+	return string(b), err
+}
+
+func parseJSONColumn(s string, v interface{}) error {
+	if s == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(s), v)
+}
+
+// nullTime stores an optional timestamp as RFC 3339 text.
+func nullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: t.Format(time.RFC3339Nano), Valid: true}
+}
+
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func insertCert(tx *sql.Tx, cert Cert) error {
+	nc := cert.NameConstraints
+	var maxPathLen sql.NullInt64
+	if cert.MaxPathLen != nil {
+		maxPathLen = sql.NullInt64{Int64: int64(*cert.MaxPathLen), Valid: true}
+	}
+	policies, err := jsonColumn(cert.Policies, len(cert.Policies) == 0)
+	if err != nil {
+		return fmt.Errorf("failed encoding policies of %v: %v", cert.Name, err)
+	}
+	extensions, err := jsonColumn(cert.Extensions, len(cert.Extensions) == 0)
+	if err != nil {
+		return fmt.Errorf("failed encoding extensions of %v: %v", cert.Name, err)
+	}
+	signProfiles, err := jsonColumn(cert.SignProfiles, len(cert.SignProfiles) == 0)
+	if err != nil {
+		return fmt.Errorf("failed encoding signProfiles of %v: %v", cert.Name, err)
+	}
+	_, err = tx.Exec(
+		`INSERT INTO certs (name, common_name, signer, expire, is_ca, is_client, profile, key_algorithm, key_size,
+			name_constraints, name_constraints_critical, max_path_len, not_before, not_after, backdate, inherit_subject,
+			policies, extensions, external, imported, csr, crl_expire, delegated_ocsp, sign_profiles)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		cert.Name, cert.CommonName, cert.Signer, int64(cert.Expire), cert.IsCA, cert.IsClient, cert.Profile, cert.KeyAlgorithm, cert.KeySize,
+		nc != nil, nc != nil && nc.Critical, maxPathLen, nullTime(cert.NotBefore), nullTime(cert.NotAfter), int64(cert.Backdate), cert.InheritSubject,
+		policies, extensions, cert.External, cert.Imported, cert.CSR, int64(cert.CRLExpire), cert.DelegatedOCSP, signProfiles,
+	)
+	if err != nil {
+		return fmt.Errorf("failed inserting certificate %v: %v", cert.Name, err)
+	}
+
+	for kind, values := range sanFields(&cert) {
+		for i, v := range *values {
+			if _, err := tx.Exec("INSERT INTO sans (cert, kind, position, value) VALUES (?, ?, ?, ?)", cert.Name, kind, i, v); err != nil {
+				return fmt.Errorf("failed inserting %v SANs of %v: %v", kind, cert.Name, err)
+			}
+		}
+	}
+	for kind, values := range usageFields(&cert) {
+		for i, v := range *values {
+			if _, err := tx.Exec("INSERT INTO usages (cert, kind, position, value) VALUES (?, ?, ?, ?)", cert.Name, kind, i, v); err != nil {
+				return fmt.Errorf("failed inserting %v usages of %v: %v", kind, cert.Name, err)
+			}
+		}
+	}
+	if nc != nil {
+		for kind, values := range constraintFields(nc) {
+			for i, v := range *values {
+				if _, err := tx.Exec("INSERT INTO name_constraints (cert, kind, position, value) VALUES (?, ?, ?, ?)", cert.Name, kind, i, v); err != nil {
+					return fmt.Errorf("failed inserting %v name constraints of %v: %v", kind, cert.Name, err)
+				}
+			}
+		}
+	}
+
+	if cert.URLs != nil {
+		for kind, values := range urlFields(cert.URLs) {
+			for i, v := range *values {
+				if _, err := tx.Exec("INSERT INTO urls (cert, kind, position, value) VALUES (?, ?, ?, ?)", cert.Name, kind, i, v); err != nil {
+					return fmt.Errorf("failed inserting %v URLs of %v: %v", kind, cert.Name, err)
+				}
+			}
+		}
+	}
+
+	subject := cert.Subject
+	fields := subjectFields(&subject)
+	if subject.SerialNumber != "" {
+		serial := []string{subject.SerialNumber}
+		fields["serialNumber"] = &serial
+	}
+	for field, values := range fields {
+		for i, v := range *values {
+			if _, err := tx.Exec("INSERT INTO subjects (cert, field, position, value) VALUES (?, ?, ?, ?)", cert.Name, field, i, v); err != nil {
+				return fmt.Errorf("failed inserting subject %v of %v: %v", field, cert.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func deleteCertRows(tx *sql.Tx, name string) error {
+	for _, table := range []string{"sans", "subjects", "usages", "name_constraints", "urls"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE cert = ?", name); err != nil {
+			return fmt.Errorf("failed deleting %v of %v: %v", table, name, err)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM certs WHERE name = ?", name); err != nil {
+		return fmt.Errorf("failed deleting certificate %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// queryCerts loads the certificates selected by where, along with their SANs,
+// usages, name constraints, URLs and subjects, ordered by name.
+func queryCerts(q querier, where string, args ...interface{}) ([]Cert, error) {
+	rows, err := q.Query(`SELECT name, common_name, signer, expire, is_ca, is_client, profile, key_algorithm, key_size,
+			name_constraints, name_constraints_critical, max_path_len, not_before, not_after, backdate, inherit_subject,
+			policies, extensions, external, imported, csr, crl_expire, delegated_ocsp, sign_profiles
+		FROM certs WHERE `+where+" ORDER BY name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying certificates: %v", err)
+	}
+
+	var certs []Cert
+	index := map[string]int{}
+	for rows.Next() {
+		var cert Cert
+		var expire, backdate, crlExpire int64
+		var constrained, critical bool
+		var maxPathLen sql.NullInt64
+		var notBefore, notAfter sql.NullString
+		var policies, extensions, signProfiles string
+		if err := rows.Scan(&cert.Name, &cert.CommonName, &cert.Signer, &expire, &cert.IsCA, &cert.IsClient, &cert.Profile, &cert.KeyAlgorithm, &cert.KeySize,
+			&constrained, &critical, &maxPathLen, &notBefore, &notAfter, &backdate, &cert.InheritSubject,
+			&policies, &extensions, &cert.External, &cert.Imported, &cert.CSR, &crlExpire, &cert.DelegatedOCSP, &signProfiles); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading certificate: %v", err)
+		}
+		cert.Expire = Duration(expire)
+		cert.Backdate = Duration(backdate)
+		cert.CRLExpire = Duration(crlExpire)
+		if maxPathLen.Valid {
+			n := int(maxPathLen.Int64)
+			cert.MaxPathLen = &n
+		}
+		if cert.NotBefore, err = parseNullTime(notBefore); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading notBefore of %v: %v", cert.Name, err)
+		}
+		if cert.NotAfter, err = parseNullTime(notAfter); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading notAfter of %v: %v", cert.Name, err)
+		}
+		if err := parseJSONColumn(policies, &cert.Policies); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading policies of %v: %v", cert.Name, err)
+		}
+		if err := parseJSONColumn(extensions, &cert.Extensions); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading extensions of %v: %v", cert.Name, err)
+		}
+		if err := parseJSONColumn(signProfiles, &cert.SignProfiles); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading signProfiles of %v: %v", cert.Name, err)
+		}
+		if constrained {
+			cert.NameConstraints = &NameConstraints{Critical: critical}
+		}
+		index[cert.Name] = len(certs)
+		certs = append(certs, cert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading certificates: %v", err)
+	}
+	rows.Close()
+	if len(certs) == 0 {
+		return nil, nil
+	}
+
+	in := "SELECT name FROM certs WHERE " + where
+	rows, err = q.Query("SELECT cert, kind, value FROM sans WHERE cert IN ("+in+") ORDER BY cert, kind, position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying SANs: %v", err)
+	}
+	for rows.Next() {
+		var name, kind, value string
+		if err := rows.Scan(&name, &kind, &value); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading SAN: %v", err)
+		}
+		if values, ok := sanFields(&certs[index[name]])[kind]; ok {
+			*values = append(*values, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading SANs: %v", err)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT cert, kind, value FROM usages WHERE cert IN ("+in+") ORDER BY cert, kind, position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying usages: %v", err)
+	}
+	for rows.Next() {
+		var name, kind, value string
+		if err := rows.Scan(&name, &kind, &value); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading usage: %v", err)
+		}
+		if values, ok := usageFields(&certs[index[name]])[kind]; ok {
+			*values = append(*values, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading usages: %v", err)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT cert, kind, value FROM name_constraints WHERE cert IN ("+in+") ORDER BY cert, kind, position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying name constraints: %v", err)
+	}
+	for rows.Next() {
+		var name, kind, value string
+		if err := rows.Scan(&name, &kind, &value); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading name constraint: %v", err)
+		}
+		nc := certs[index[name]].NameConstraints
+		if nc == nil {
+			continue
+		}
+		if values, ok := constraintFields(nc)[kind]; ok {
+			*values = append(*values, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading name constraints: %v", err)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT cert, kind, value FROM urls WHERE cert IN ("+in+") ORDER BY cert, kind, position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying URLs: %v", err)
+	}
+	for rows.Next() {
+		var name, kind, value string
+		if err := rows.Scan(&name, &kind, &value); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed reading URL: %v", err)
+		}
+		cert := &certs[index[name]]
+		if cert.URLs == nil {
+			cert.URLs = &URLs{}
+		}
+		if values, ok := urlFields(cert.URLs)[kind]; ok {
+			*values = append(*values, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading URLs: %v", err)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT cert, field, value FROM subjects WHERE cert IN ("+in+") ORDER BY cert, field, position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed querying subjects: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, field, value string
+		if err := rows.Scan(&name, &field, &value); err != nil {
+			return nil, fmt.Errorf("failed reading subject: %v", err)
+		}
+		subject := &certs[index[name]].Subject
+		if field == "serialNumber" {
+			subject.SerialNumber = value
+		} else if values, ok := subjectFields(subject)[field]; ok {
+			*values = append(*values, value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading subjects: %v", err)
+	}
+
+	return certs, nil
+}
+
+func exists(q querier, name string) (bool, error) {
+	var n int
+	if err := q.QueryRow("SELECT COUNT(*) FROM certs WHERE name = ?", name).Scan(&n); err != nil {
+		return false, fmt.Errorf("failed looking up %v: %v", name, err)
+	}
+
+	return n > 0, nil
+}
+
+func countSigned(q querier, name string) (int, error) {
+	var n int
+	if err := q.QueryRow("SELECT COUNT(*) FROM certs WHERE signer = ? AND name != signer", name).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed counting certificates signed by %v: %v", name, err)
+	}
+
+	return n, nil
+}
+
+func (s *SQLite) Add(cert Cert) error {
+	if cert.Name == "" {
+		return fmt.Errorf("cannot add a certificate without a name")
+	}
+
+	return s.tx(func(tx *sql.Tx) error {
+		found, err := exists(tx, cert.Name)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("cannot add %v: %w", cert.Name, ErrExists)
+		}
+
+		return insertCert(tx, cert)
+	})
+}
+
+func (s *SQLite) Get(name string) (*Cert, error) {
+	certs, err := queryCerts(s.db, "name = ?", name)
+	if err != nil || len(certs) == 0 {
+		return nil, err
+	}
+
+	return &certs[0], nil
+}
+
+func (s *SQLite) Update(name string, cert Cert) error {
+	if cert.Name == "" {
+		return fmt.Errorf("cannot update %v to a certificate without a name", name)
+	}
+
+	return s.tx(func(tx *sql.Tx) error {
+		found, err := exists(tx, name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("cannot update %v: %w", name, ErrNotFound)
+		}
+		if cert.Name != name {
+			if found, err := exists(tx, cert.Name); err != nil {
+				return err
+			} else if found {
+				return fmt.Errorf("cannot rename %v to %v: %w", name, cert.Name, ErrExists)
+			}
+			if n, err := countSigned(tx, name); err != nil {
+				return err
+			} else if n > 0 {
+				return fmt.Errorf("cannot rename %v: %w", name, ErrHasChildren)
+			}
+		}
+
+		if err := deleteCertRows(tx, name); err != nil {
+			return err
+		}
+
+		return insertCert(tx, cert)
+	})
+}
+
+func (s *SQLite) Delete(name string, cascade bool) error {
+	return s.tx(func(tx *sql.Tx) error {
+		found, err := exists(tx, name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("cannot delete %v: %w", name, ErrNotFound)
+		}
+		n, err := countSigned(tx, name)
+		if err != nil {
+			return err
+		}
+		if n > 0 && !cascade {
+			return fmt.Errorf("cannot delete %v: %w", name, ErrHasChildren)
+		}
+
+		rows, err := tx.Query(`
+			WITH RECURSIVE below(name) AS (
+				SELECT name FROM certs WHERE signer = ? AND name != signer
+				UNION
+				SELECT c.name FROM certs c JOIN below b ON c.signer = b.name AND c.name != c.signer
+			)
+			SELECT name FROM below`, name)
+		if err != nil {
+			return fmt.Errorf("failed finding certificates below %v: %v", name, err)
+		}
+		remove := []string{name}
+		for rows.Next() {
+			var n string
+			if err := rows.Scan(&n); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed reading certificates below %v: %v", name, err)
+			}
+			remove = append(remove, n)
+		}
+		rows.Close()
+
+		for _, n := range remove {
+			if err := deleteCertRows(tx, n); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *SQLite) List(filter Filter) ([]Cert, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+	if filter.Signer != "" {
+		where = append(where, "signer = ?")
+		args = append(args, filter.Signer)
+	}
+	if filter.IsCA != nil {
+		where = append(where, "is_ca = ?")
+		args = append(args, *filter.IsCA)
+	}
+
+	return queryCerts(s.db, strings.Join(where, " AND "), args...)
+}
+
+// Tree loads every definition at once and arranges them below their roots,
+// so reading the database fails here rather than while walking the tree.
+func (s *SQLite) Tree() ([]TreeNode, error) {
+	certs, err := s.List(Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTree(certs), nil
+}
+
+// Profiles returns the profiles copied from the seeding configuration.
+func (s *SQLite) Profiles() (map[string]Profile, error) {
+	rows, err := s.db.Query("SELECT name, definition FROM profiles")
+	if err != nil {
+		return nil, fmt.Errorf("failed querying profiles: %v", err)
+	}
+	defer rows.Close()
+
+	profiles := map[string]Profile{}
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			return nil, fmt.Errorf("failed reading profile: %v", err)
+		}
+		var p Profile
+		if err := json.Unmarshal([]byte(definition), &p); err != nil {
+			return nil, fmt.Errorf("failed decoding profile %v: %v", name, err)
+		}
+		profiles[name] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading profiles: %v", err)
+	}
+
+	return profiles, nil
+}
+
+// Seed copies every definition, and the profiles when from has any, from the
+// given store unless the database has been seeded before. It reports whether
+// anything was copied.
+func (s *SQLite) Seed(from Store) (bool, error) {
+	certs, err := from.List(Filter{})
+	if err != nil {
+		return false, fmt.Errorf("failed listing certificates to seed: %v", err)
+	}
+	profiles, err := definedProfiles(from)
+	if err != nil {
+		return false, err
+	}
+
+	seeded := false
+	err = s.tx(func(tx *sql.Tx) error {
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM meta WHERE key = 'seeded'").Scan(&n); err != nil {
+			return fmt.Errorf("failed reading seed marker: %v", err)
+		}
+		if n > 0 {
+			return nil
+		}
+
+		for _, cert := range certs {
+			if err := insertCert(tx, cert); err != nil {
+				return err
+			}
+		}
+		for name, p := range profiles {
+			definition, err := json.Marshal(p)
+			if err != nil {
+				return fmt.Errorf("failed encoding profile %v: %v", name, err)
+			}
+			if _, err := tx.Exec("INSERT INTO profiles (name, definition) VALUES (?, ?)", name, string(definition)); err != nil {
+				return fmt.Errorf("failed inserting profile %v: %v", name, err)
+			}
+		}
+		if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES ('seeded', '1')"); err != nil {
+			return fmt.Errorf("failed writing seed marker: %v", err)
+		}
+		seeded = true
+
+		return nil
+	})
+
+	return seeded, err
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSQLiteMigrations creates databases at every older schema version with
+// a definition in them and checks opening migrates them to the latest one,
+// keeping the definition.
+func TestSQLiteMigrations(t *testing.T) {
+	for version := 1; version < len(sqliteMigrations); version++ {
+		t.Run(fmt.Sprintf("from %d", version), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pki.sqlite")
+			db, err := sql.Open("sqlite", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < version; i++ {
+				if _, err := db.Exec(sqliteMigrations[i]); err != nil {
+					t.Fatalf("migration %d: %v", i+1, err)
+				}
+			}
+			stmts := []string{
+				fmt.Sprintf("PRAGMA user_version = %d", version),
+				`INSERT INTO certs (name, common_name, signer, expire, is_ca) VALUES ('web', 'web.acme.internal', 'Root CA', 3600000000000, 0)`,
+				`INSERT INTO sans (cert, kind, position, value) VALUES ('web', 'dns', 0, 'web.acme.internal')`,
+				`INSERT INTO subjects (cert, field, position, value) VALUES ('web', 'organization', 0, 'Acme Inc.')`,
+			}
+			for _, stmt := range stmts {
+				if _, err := db.Exec(stmt); err != nil {
+					t.Fatalf("%v: %v", stmt, err)
+				}
+			}
+			db.Close()
+
+			s, err := OpenSQLite(path)
+			if err != nil {
+				t.Fatalf("OpenSQLite: %v", err)
+			}
+			defer s.Close()
+			var got int
+			if err := s.db.QueryRow("PRAGMA user_version").Scan(&got); err != nil || got != len(sqliteMigrations) {
+				t.Errorf("user_version after migrating = %d, %v; want %d", got, err, len(sqliteMigrations))
+			}
+			cert, err := s.Get("web")
+			if err != nil || cert == nil {
+				t.Fatalf("Get(web) after migrating = %v, %v", cert, err)
+			}
+			want := Cert{
+				Name:       "web",
+				CommonName: "web.acme.internal",
+				Subject:    Subject{Organization: []string{"Acme Inc."}},
+				DNSNames:   []string{"web.acme.internal"},
+				Signer:     "Root CA",
+				Expire:     Duration(time.Hour),
+			}
+			cert.Source = Source{}
+			if !reflect.DeepEqual(*cert, want) {
+				t.Errorf("Get(web) after migrating = %+v; want %+v", *cert, want)
+			}
+		})
+	}
+}
+
+func TestSQLiteNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pki.sqlite")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(sqliteMigrations)+1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if s, err := OpenSQLite(path); err == nil {
+		s.Close()
+		t.Error("OpenSQLite of a newer schema succeeded")
+	}
+}
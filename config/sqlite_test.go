@@ -0,0 +1,33 @@
+package config_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"easypki-ui/config"
+	"easypki-ui/config/storetest"
+)
+
+func openSQLite(t *testing.T) *config.SQLite {
+	t.Helper()
+	s, err := config.OpenSQLite(filepath.Join(t.TempDir(), "pki.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestSQLiteStore(t *testing.T) {
+	err := storetest.TestStore(func() (config.Store, error) {
+		return openSQLite(t), nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteSeed(t *testing.T) {
+	checkSeed(t, openSQLite(t))
+}
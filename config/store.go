@@ -1,101 +1,246 @@
 package config
 
 import (
-	"time"
 	"crypto/x509/pkix"
-	"github.com/google/easypki/pkg/easypki"
-	"crypto/x509"
-	"github.com/google/easypki/pkg/certificate"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/easypki/pkg/easypki"
 )
 
+// Store holds the certificate definitions the PKI is generated from.
 type Store interface {
+	// Add stores a new certificate definition, failing with ErrExists if
+	// one with the same name is already present.
 	Add(cert Cert) error
+	// Get returns the named definition, or nil if there is none.
 	Get(name string) (*Cert, error)
+	// Update replaces the definition stored under name with cert.
+	Update(name string, cert Cert) error
+	// Delete removes the named definition. Certificates that still sign
+	// others are only removed, together with everything below them, when
+	// cascade is set.
+	Delete(name string, cascade bool) error
+	// List returns all definitions matching filter, ordered by name.
+	List(filter Filter) ([]Cert, error)
 	Tree() ([]TreeNode, error)
 }
 
+// Store errors.
+var (
+	ErrNotFound    = errors.New("certificate not found")
+	ErrExists      = errors.New("certificate already exists")
+	ErrHasChildren = errors.New("certificate still signs other certificates")
+)
+
+// Filter restricts the definitions returned by Store.List. The zero value
+// matches everything.
+type Filter struct {
+	// Signer only matches certificates signed by the named CA.
+	Signer string
+	// IsCA, when set, only matches CAs or only non-CAs.
+	IsCA *bool
+}
+
+// Match reports whether cert passes the filter.
+func (f Filter) Match(cert Cert) bool {
+	if f.Signer != "" && cert.Signer != f.Signer {
+		return false
+	}
+	if f.IsCA != nil && cert.IsCA != *f.IsCA {
+		return false
+	}
+
+	return true
+}
+
 type TreeNode interface {
 	Children() []TreeNode
 	Self() Cert
 }
 
 type Cert struct {
-	Name           string    `yaml:"name"`
-	Subject        pkix.Name `yaml:"subject"`
-	CommonName     string    `yaml:"commonName"`
-	DNSNames       []string  `yaml:"dnsNames"`
-	EmailAddresses []string  `yaml:"emailAddresses"`
+	Name    string  `yaml:"name" json:"name" toml:"name"`
+	Subject Subject `yaml:"subject,omitempty" json:"subject" toml:"subject,omitempty"`
+	// InheritSubject fills the subject fields left unset from the signer's
+	// subject. CommonName and the subject fields may also be templates
+	// executed with TemplateData, such as "{{.Name}}".
+	InheritSubject bool     `yaml:"inheritSubject,omitempty" json:"inheritSubject,omitempty" toml:"inheritSubject,omitempty"`
+	CommonName     string   `yaml:"commonName,omitempty" json:"commonName,omitempty" toml:"commonName,omitempty"`
+	DNSNames       []string `yaml:"dnsNames,omitempty" json:"dnsNames,omitempty" toml:"dnsNames,omitempty"`
+	EmailAddresses []string `yaml:"emailAddresses,omitempty" json:"emailAddresses,omitempty" toml:"emailAddresses,omitempty"`
+	IPAddresses    []string `yaml:"ipAddresses,omitempty" json:"ipAddresses,omitempty" toml:"ipAddresses,omitempty"`
+	URIs           []string `yaml:"uris,omitempty" json:"uris,omitempty" toml:"uris,omitempty"`
+
+	Signer string   `yaml:"signer,omitempty" json:"signer,omitempty" toml:"signer,omitempty"`
+	Expire Duration `yaml:"expire,omitempty" json:"expire,omitempty" toml:"expire,omitzero"`
+
+	// NotBefore and NotAfter fix the validity window instead of deriving it
+	// from the issue time, backdate and expire. Certificates never outlive
+	// their signer; a later notAfter is clamped to the signer's.
+	NotBefore *time.Time `yaml:"notBefore,omitempty" json:"notBefore,omitempty" toml:"notBefore,omitempty"`
+	NotAfter  *time.Time `yaml:"notAfter,omitempty" json:"notAfter,omitempty" toml:"notAfter,omitempty"`
+	Backdate  Duration   `yaml:"backdate,omitempty" json:"backdate,omitempty" toml:"backdate,omitzero"`
+
+	IsCA     bool `yaml:"isCA,omitempty" json:"isCA,omitempty" toml:"isCA,omitempty"`
+	IsClient bool `yaml:"isClient,omitempty" json:"isClient,omitempty" toml:"isClient,omitempty"`
+	// MaxPathLen limits how many CAs may follow this one, replacing the
+	// profile's; 0 makes an issuing CA that only signs end entities.
+	MaxPathLen *int `yaml:"maxPathLen,omitempty" json:"maxPathLen,omitempty" toml:"maxPathLen,omitempty"`
+	// External CAs are signed outside of the PKI, by the CA Signer names
+	// for reference if at all. Their key and certificate request are
+	// generated here and the signed certificate imported.
+	External bool `yaml:"external,omitempty" json:"external,omitempty" toml:"external,omitempty"`
+	// Imported CAs have their certificate and key generated elsewhere and
+	// imported; they are never issued here.
+	Imported bool `yaml:"imported,omitempty" json:"imported,omitempty" toml:"imported,omitempty"`
+	// CSR is a PEM certificate request the certificate is issued for. Its
+	// key stays with the requester; only its subject and alternative names
+	// as copied into the definition are certified.
+	CSR string `yaml:"csr,omitempty" json:"csr,omitempty" toml:"csr,omitempty"`
+
+	// NameConstraints restricts the names a CA and the CAs below it can
+	// issue certificates for.
+	NameConstraints *NameConstraints `yaml:"nameConstraints,omitempty" json:"nameConstraints,omitempty" toml:"nameConstraints,omitempty"`
+	// URLs of a CA are stamped into the certificates it signs, so relying
+	// parties can check their revocation.
+	URLs *URLs `yaml:"urls,omitempty" json:"urls,omitempty" toml:"urls,omitempty"`
+	// CRLExpire is how long the CRLs a CA signs are valid, 24h by default.
+	// They are signed again once half of that has passed.
+	CRLExpire Duration `yaml:"crlExpire,omitempty" json:"crlExpire,omitempty" toml:"crlExpire,omitzero"`
+	// DelegatedOCSP has the CA's OCSP responses signed by a short lived OCSP
+	// signing certificate it issues itself rather than by its own key.
+	DelegatedOCSP bool `yaml:"delegatedOCSP,omitempty" json:"delegatedOCSP,omitempty" toml:"delegatedOCSP,omitempty"`
+	// SignProfiles are the profiles certificate requests signed by a CA
+	// through the API may ask for, the first being the default. Without
+	// any, requests get the default profile and cannot ask for another.
+	SignProfiles []string `yaml:"signProfiles,omitempty" json:"signProfiles,omitempty" toml:"signProfiles,omitempty"`
+
+	// Policies are the certificate policies the certificate is issued under
+	// and Extensions further extensions added as is.
+	Policies   []Policy    `yaml:"policies,omitempty" json:"policies,omitempty" toml:"policies,omitempty"`
+	Extensions []Extension `yaml:"extensions,omitempty" json:"extensions,omitempty" toml:"extensions,omitempty"`
+
+	// Profile names the profile the certificate is issued with. Without one
+	// CAs use "ca", client certificates "tls-client" and anything else
+	// "server+client". KeyUsage and ExtKeyUsage replace the profile's.
+	Profile     string   `yaml:"profile,omitempty" json:"profile,omitempty" toml:"profile,omitempty"`
+	KeyUsage    []string `yaml:"keyUsage,omitempty" json:"keyUsage,omitempty" toml:"keyUsage,omitempty"`
+	ExtKeyUsage []string `yaml:"extKeyUsage,omitempty" json:"extKeyUsage,omitempty" toml:"extKeyUsage,omitempty"`
+
+	// KeyAlgorithm is rsa, the default, ecdsa or ed25519. KeySize is the RSA
+	// modulus size, 2048 by default, or the ECDSA curve size, 256 by default.
+	KeyAlgorithm string `yaml:"keyAlgorithm,omitempty" json:"keyAlgorithm,omitempty" toml:"keyAlgorithm,omitempty"`
+	KeySize      int    `yaml:"keySize,omitempty" json:"keySize,omitempty" toml:"keySize,omitzero"`
+
+	// Source is where the definition was read from, when known.
+	Source Source `yaml:"-" json:"-" toml:"-"`
+}
 
-	Signer string        `yaml:"signer"`
-	Expire time.Duration `yaml:"expire"`
+// Source is a position in a configuration file.
+type Source struct {
+	File string
+	Line int
+}
 
-	IsCA     bool `yaml:"isCA"`
-	IsClient bool `yaml:"isClient"`
+func (s Source) String() string {
+	switch {
+	case s.File == "":
+		return ""
+	case s.Line == 0:
+		return s.File
+	default:
+		return fmt.Sprintf("%v:%d", s.File, s.Line)
+	}
 }
 
-type Config struct {
-	Store   Store
-	EasyPKI *easypki.EasyPKI
+// Subject holds the distinguished name fields of a certificate. The common
+// name is kept on Cert itself.
+type Subject struct {
+	Country            []string `yaml:"country,omitempty" json:"country,omitempty" toml:"country,omitempty"`
+	Organization       []string `yaml:"organization,omitempty" json:"organization,omitempty" toml:"organization,omitempty"`
+	OrganizationalUnit []string `yaml:"organizationalUnit,omitempty" json:"organizationalUnit,omitempty" toml:"organizationalUnit,omitempty"`
+	Locality           []string `yaml:"locality,omitempty" json:"locality,omitempty" toml:"locality,omitempty"`
+	Province           []string `yaml:"province,omitempty" json:"province,omitempty" toml:"province,omitempty"`
+	StreetAddress      []string `yaml:"streetAddress,omitempty" json:"streetAddress,omitempty" toml:"streetAddress,omitempty"`
+	PostalCode         []string `yaml:"postalCode,omitempty" json:"postalCode,omitempty" toml:"postalCode,omitempty"`
+	SerialNumber       string   `yaml:"serialNumber,omitempty" json:"serialNumber,omitempty" toml:"serialNumber,omitempty"`
 }
 
-func (c *Config) Init() error {
-	tree, err := c.Store.Tree()
-	if err != nil {
-		return err
+// Name returns the subject as a pkix.Name with the given common name.
+func (s Subject) Name(commonName string) pkix.Name {
+	return pkix.Name{
+		Country:            s.Country,
+		Organization:       s.Organization,
+		OrganizationalUnit: s.OrganizationalUnit,
+		Locality:           s.Locality,
+		Province:           s.Province,
+		StreetAddress:      s.StreetAddress,
+		PostalCode:         s.PostalCode,
+		SerialNumber:       s.SerialNumber,
+		CommonName:         commonName,
 	}
+}
 
-	for _, node := range tree {
-		err := c.walk(node)
-		if err != nil {
-			return err
+// signedBy returns the certificates in certs directly signed by name.
+func signedBy(certs []Cert, name string) []Cert {
+	var signed []Cert
+	for _, cert := range certs {
+		if cert.Signer == name && cert.Name != name {
+			signed = append(signed, cert)
 		}
 	}
 
-	return nil
+	return signed
 }
 
-func (c *Config) walk(node TreeNode) error {
-	c.makeCert(node.Self())
-	for _, node := range node.Children() {
-		err := c.walk(node)
-		if err != nil {
-			return err
+// descendants returns the names of every certificate below name in the
+// signing hierarchy described by certs.
+func descendants(certs []Cert, name string) []string {
+	var names []string
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		for _, cert := range signedBy(certs, queue[0]) {
+			if !seen[cert.Name] {
+				seen[cert.Name] = true
+				names = append(names, cert.Name)
+				queue = append(queue, cert.Name)
+			}
 		}
+		queue = queue[1:]
 	}
 
-	return nil
+	return names
 }
 
-func (c *Config) makeCert(cert Cert) error {
-	req := &easypki.Request{
-		Name: cert.Name,
-		Template: &x509.Certificate{
-			Subject:        cert.Subject,
-			NotAfter:       time.Now().Add(cert.Expire),
-			IsCA:           cert.IsCA,
-			DNSNames:       cert.DNSNames,
-			EmailAddresses: cert.EmailAddresses,
-		},
-		IsClientCertificate: cert.IsClient,
-	}
-	if cert.IsCA {
-		req.Template.MaxPathLen = -1
-	}
-	req.Template.Subject.CommonName = cert.CommonName
-
-	var signer *certificate.Bundle
-	var err error
-	if cert.Signer != "" {
-		signer, err = c.EasyPKI.GetCA(cert.Signer)
-		if err != nil {
-			return fmt.Errorf("cannot sign %v because cannot get CA %v: %v", cert.Name, cert.Signer, err)
-		}
-	}
+type Config struct {
+	Store   Store
+	EasyPKI *easypki.EasyPKI
 
-	if err := c.EasyPKI.Sign(signer, req); err != nil {
-		return fmt.Errorf("cannot create bundle for %v: %v", cert.Name, err)
+	// issuing serializes reconciling, creating, importing and revoking, so
+	// nothing gets signed by a CA being replaced at the same time.
+	issuing sync.Mutex
+	// signing serializes replacing CRLs and delegated OCSP signers, which
+	// are read, renewed and saved.
+	signing sync.Mutex
+	ocsp    ocspCache
+}
+
+// Init reconciles the issued bundles with the configured tree, issuing
+// missing certificates and re-issuing those whose definition changed. It
+// returns the outcome for every certificate in the tree; failing certificates
+// do not stop the others from being reconciled.
+func (c *Config) Init() ([]Result, error) {
+	c.issuing.Lock()
+	defer c.issuing.Unlock()
+
+	plan, err := c.Plan()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return c.Apply(plan), nil
 }
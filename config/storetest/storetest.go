@@ -0,0 +1,354 @@
+// Package storetest implements conformance checks for config.Store
+// implementations.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"easypki-ui/config"
+)
+
+// TestStore runs the conformance suite against stores returned by newStore.
+// Every call to newStore must return a new, empty store. It returns an error
+// describing every check that failed.
+func TestStore(newStore func() (config.Store, error)) error {
+	var errs []string
+	for _, check := range checks {
+		s, err := newStore()
+		if err != nil {
+			return fmt.Errorf("failed creating store: %v", err)
+		}
+		if err := check.fn(s); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", check.name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		msg := "store failed conformance checks:"
+		for _, e := range errs {
+			msg += "\n\t" + e
+		}
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+var (
+	zero      = 0
+	notBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+var subject = config.Subject{
+	Country:      []string{"US"},
+	Organization: []string{"Acme Inc."},
+}
+
+var (
+	root = config.Cert{
+		Name:       "Root CA",
+		Subject:    subject,
+		CommonName: "Root CA",
+		Expire:     config.Duration(720 * time.Hour),
+		IsCA:       true,
+		External:   true,
+		KeySize:    4096,
+		URLs:       &config.URLs{Base: []string{"https://pki.acme.internal/api"}},
+		CRLExpire:  config.Duration(7 * 24 * time.Hour),
+	}
+	intermediate = config.Cert{
+		Name:          "Intermediate CA",
+		Subject:       subject,
+		CommonName:    "Intermediate CA",
+		Signer:        "Root CA",
+		Expire:        config.Duration(720 * time.Hour),
+		Backdate:      config.Duration(time.Hour),
+		IsCA:          true,
+		Imported:      true,
+		MaxPathLen:    &zero,
+		DelegatedOCSP: true,
+		SignProfiles:  []string{"tls-server", "tls-client"},
+		NameConstraints: &config.NameConstraints{
+			Critical: true,
+			Permitted: config.NameSet{
+				DNSDomains:     []string{".acme.internal"},
+				EmailAddresses: []string{"acme.com"},
+			},
+			Excluded: config.NameSet{IPRanges: []string{"192.168.0.0/16"}},
+		},
+		URLs: &config.URLs{
+			Base: []string{"https://pki.acme.internal/api"},
+			CRL:  []string{"http://crl.acme.internal/intermediate.crl"},
+		},
+	}
+	server = config.Cert{
+		Name:         "server",
+		Subject:      subject,
+		CommonName:   "server.acme.internal",
+		DNSNames:     []string{"server.acme.internal"},
+		IPAddresses:  []string{"10.0.0.1", "fd00::1"},
+		URIs:         []string{"spiffe://acme.internal/server"},
+		Signer:       "Intermediate CA",
+		Expire:       config.Duration(24 * time.Hour),
+		Profile:      "tls-server",
+		KeyAlgorithm: "ecdsa",
+		KeySize:      384,
+	}
+	client = config.Cert{
+		Name:           "bob@acme.com",
+		InheritSubject: true,
+		CommonName:     "{{.Name}}",
+		EmailAddresses: []string{"bob@acme.com"},
+		Signer:         "Intermediate CA",
+		NotBefore:      &notBefore,
+		IsClient:       true,
+		KeyUsage:       []string{"digitalSignature", "keyAgreement"},
+		ExtKeyUsage:    []string{"clientAuth", "emailProtection"},
+		Policies: []config.Policy{
+			{OID: "1.3.6.1.4.1.99999.1.1", CPS: []string{"https://pki.acme.internal/cps"}, UserNotice: "Internal use only"},
+			{OID: "2.23.140.1.2.1"},
+		},
+		Extensions: []config.Extension{
+			{OID: "1.3.6.1.4.1.99999.2.1", UTF8: "finance"},
+			{OID: "1.3.6.1.4.1.99999.2.2", Critical: true, DER: "01:01:ff"},
+		},
+		CSR: "-----BEGIN CERTIFICATE REQUEST-----\nMIHTMHsCAQAwGTEXMBUGA1UEAwwOYm9iQGFjbWUuY29t\n-----END CERTIFICATE REQUEST-----\n",
+	}
+)
+
+var checks = []struct {
+	name string
+	fn   func(s config.Store) error
+}{
+	{"empty", checkEmpty},
+	{"add and get", checkAddGet},
+	{"add duplicate", checkAddDuplicate},
+	{"list", checkList},
+	{"tree", checkTree},
+	{"update", checkUpdate},
+	{"delete", checkDelete},
+	{"delete cascade", checkDeleteCascade},
+}
+
+func seed(s config.Store) error {
+	for _, cert := range []config.Cert{root, intermediate, server, client} {
+		if err := s.Add(cert); err != nil {
+			return fmt.Errorf("Add(%v): %v", cert.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func names(certs []config.Cert) []string {
+	n := []string{}
+	for _, cert := range certs {
+		n = append(n, cert.Name)
+	}
+
+	return n
+}
+
+func checkEmpty(s config.Store) error {
+	cert, err := s.Get(root.Name)
+	if err != nil || cert != nil {
+		return fmt.Errorf("Get on empty store = %v, %v; want nil, nil", cert, err)
+	}
+	certs, err := s.List(config.Filter{})
+	if err != nil || len(certs) != 0 {
+		return fmt.Errorf("List on empty store = %v, %v; want no certificates", names(certs), err)
+	}
+	tree, err := s.Tree()
+	if err != nil || len(tree) != 0 {
+		return fmt.Errorf("Tree on empty store = %d roots, %v; want none", len(tree), err)
+	}
+
+	return nil
+}
+
+func checkAddGet(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+
+	for _, want := range []config.Cert{root, intermediate, server, client} {
+		got, err := s.Get(want.Name)
+		if err != nil {
+			return fmt.Errorf("Get(%v): %v", want.Name, err)
+		}
+		if got == nil {
+			return fmt.Errorf("Get(%v) = nil after Add", want.Name)
+		}
+		got.Source = config.Source{}
+		if !reflect.DeepEqual(*got, want) {
+			return fmt.Errorf("Get(%v) = %+v; want %+v", want.Name, *got, want)
+		}
+	}
+
+	return nil
+}
+
+func checkAddDuplicate(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+	if err := s.Add(server); !errors.Is(err, config.ErrExists) {
+		return fmt.Errorf("Add of existing %v = %v; want ErrExists", server.Name, err)
+	}
+
+	return nil
+}
+
+func checkList(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+
+	yes, no := true, false
+	for _, tc := range []struct {
+		filter config.Filter
+		want   []string
+	}{
+		{config.Filter{}, []string{"Intermediate CA", "Root CA", "bob@acme.com", "server"}},
+		{config.Filter{Signer: "Intermediate CA"}, []string{"bob@acme.com", "server"}},
+		{config.Filter{IsCA: &yes}, []string{"Intermediate CA", "Root CA"}},
+		{config.Filter{IsCA: &no}, []string{"bob@acme.com", "server"}},
+		{config.Filter{Signer: "Root CA", IsCA: &no}, []string{}},
+	} {
+		certs, err := s.List(tc.filter)
+		if err != nil {
+			return fmt.Errorf("List(%+v): %v", tc.filter, err)
+		}
+		if got := names(certs); !reflect.DeepEqual(got, tc.want) {
+			return fmt.Errorf("List(%+v) = %v; want %v", tc.filter, got, tc.want)
+		}
+	}
+
+	return nil
+}
+
+func checkTree(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+
+	tree, err := s.Tree()
+	if err != nil {
+		return fmt.Errorf("Tree: %v", err)
+	}
+	if len(tree) != 1 || tree[0].Self().Name != root.Name {
+		return fmt.Errorf("Tree returned %d roots; want only %v", len(tree), root.Name)
+	}
+	level := tree[0].Children()
+	if len(level) != 1 || level[0].Self().Name != intermediate.Name {
+		return fmt.Errorf("%v has %d children; want only %v", root.Name, len(level), intermediate.Name)
+	}
+	leafs := map[string]bool{}
+	for _, node := range level[0].Children() {
+		leafs[node.Self().Name] = true
+		if len(node.Children()) != 0 {
+			return fmt.Errorf("leaf %v has children", node.Self().Name)
+		}
+	}
+	if len(leafs) != 2 || !leafs[server.Name] || !leafs[client.Name] {
+		return fmt.Errorf("%v has children %v; want %v and %v", intermediate.Name, leafs, server.Name, client.Name)
+	}
+
+	return nil
+}
+
+func checkUpdate(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+
+	updated := server
+	updated.DNSNames = []string{"server.acme.internal", "www.acme.internal"}
+	if err := s.Update(server.Name, updated); err != nil {
+		return fmt.Errorf("Update(%v): %v", server.Name, err)
+	}
+	got, err := s.Get(server.Name)
+	if err != nil || got == nil {
+		return fmt.Errorf("Get(%v) after Update = %v, %v", server.Name, got, err)
+	}
+	got.Source = config.Source{}
+	if !reflect.DeepEqual(*got, updated) {
+		return fmt.Errorf("Get(%v) after Update = %+v; want %+v", server.Name, *got, updated)
+	}
+
+	renamed := updated
+	renamed.Name = "web"
+	if err := s.Update(server.Name, renamed); err != nil {
+		return fmt.Errorf("Update renaming %v: %v", server.Name, err)
+	}
+	if got, err := s.Get(server.Name); err != nil || got != nil {
+		return fmt.Errorf("Get(%v) after rename = %v, %v; want nil, nil", server.Name, got, err)
+	}
+	if got, err := s.Get(renamed.Name); err != nil || got == nil {
+		return fmt.Errorf("Get(%v) after rename = %v, %v", renamed.Name, got, err)
+	}
+
+	if err := s.Update("missing", server); !errors.Is(err, config.ErrNotFound) {
+		return fmt.Errorf("Update of missing certificate = %v; want ErrNotFound", err)
+	}
+	if err := s.Update(renamed.Name, client); !errors.Is(err, config.ErrExists) {
+		return fmt.Errorf("Update renaming onto %v = %v; want ErrExists", client.Name, err)
+	}
+	ca := intermediate
+	ca.Name = "Other CA"
+	if err := s.Update(intermediate.Name, ca); !errors.Is(err, config.ErrHasChildren) {
+		return fmt.Errorf("Update renaming signing CA = %v; want ErrHasChildren", err)
+	}
+
+	return nil
+}
+
+func checkDelete(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+
+	if err := s.Delete(intermediate.Name, false); !errors.Is(err, config.ErrHasChildren) {
+		return fmt.Errorf("Delete of signing CA = %v; want ErrHasChildren", err)
+	}
+	if err := s.Delete("missing", false); !errors.Is(err, config.ErrNotFound) {
+		return fmt.Errorf("Delete of missing certificate = %v; want ErrNotFound", err)
+	}
+	if err := s.Delete(server.Name, false); err != nil {
+		return fmt.Errorf("Delete(%v): %v", server.Name, err)
+	}
+	if got, err := s.Get(server.Name); err != nil || got != nil {
+		return fmt.Errorf("Get(%v) after Delete = %v, %v; want nil, nil", server.Name, got, err)
+	}
+	certs, err := s.List(config.Filter{})
+	if err != nil {
+		return fmt.Errorf("List: %v", err)
+	}
+	if got := names(certs); len(got) != 3 {
+		return fmt.Errorf("List after Delete = %v; want 3 certificates", got)
+	}
+
+	return nil
+}
+
+func checkDeleteCascade(s config.Store) error {
+	if err := seed(s); err != nil {
+		return err
+	}
+
+	if err := s.Delete(root.Name, true); err != nil {
+		return fmt.Errorf("Delete(%v, cascade): %v", root.Name, err)
+	}
+	certs, err := s.List(config.Filter{})
+	if err != nil {
+		return fmt.Errorf("List: %v", err)
+	}
+	if len(certs) != 0 {
+		return fmt.Errorf("List after cascading Delete = %v; want no certificates", names(certs))
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateData is what templated commonName and subject fields are executed
+// against, e.g. "{{.Name}}" or "{{.Signer.Subject.OrganizationalUnit}}".
+type TemplateData struct {
+	Name           string
+	DNSNames       []string
+	EmailAddresses []string
+	// Signer is the signer's definition with its subject resolved, or nil
+	// for self signed certificates.
+	Signer *Cert
+}
+
+// resolveSubject returns cert with its commonName and subject templates
+// executed and, with inheritSubject, the subject fields it leaves unset taken
+// from signer, which must already be resolved.
+func resolveSubject(cert Cert, signer *Cert) (Cert, error) {
+	data := TemplateData{
+		Name:           cert.Name,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Signer:         signer,
+	}
+
+	var err error
+	if cert.CommonName, err = executeField("commonName", cert.CommonName, data); err != nil {
+		return cert, err
+	}
+	if cert.Subject.SerialNumber, err = executeField("serialNumber", cert.Subject.SerialNumber, data); err != nil {
+		return cert, err
+	}
+
+	subject := cert.Subject
+	var own, inherited map[string]*[]string
+	own = subjectFields(&subject)
+	if cert.InheritSubject && signer != nil {
+		inherited = subjectFields(&signer.Subject)
+	}
+	for field, values := range own {
+		resolved, err := executeList(field, *values, data)
+		if err != nil {
+			return cert, err
+		}
+		if len(resolved) == 0 && inherited != nil {
+			resolved = append([]string(nil), *inherited[field]...)
+		}
+		*values = resolved
+	}
+	cert.Subject = subject
+
+	return cert, nil
+}
+
+// resolveSubjects resolves the subjects of all definitions, signers first.
+// Certificates below one that fails to resolve are resolved against its
+// unresolved definition. Signer cycles are left out of both results.
+func resolveSubjects(byName map[string]Cert) (map[string]Cert, map[string]error) {
+	resolved := map[string]Cert{}
+	errs := map[string]error{}
+	visiting := map[string]bool{}
+
+	var resolve func(name string) bool
+	resolve = func(name string) bool {
+		if _, ok := resolved[name]; ok {
+			return true
+		}
+		cert, ok := byName[name]
+		if !ok || visiting[name] || errs[name] != nil {
+			return false
+		}
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		var signer *Cert
+		if !selfSigned(cert) {
+			if s, defined := byName[cert.Signer]; defined {
+				if resolve(cert.Signer) {
+					s = resolved[cert.Signer]
+				} else if errs[cert.Signer] == nil {
+					return false
+				}
+				signer = &s
+			}
+		}
+		r, err := resolveSubject(cert, signer)
+		if err != nil {
+			errs[name] = err
+			return false
+		}
+		resolved[name] = r
+		return true
+	}
+	for name := range byName {
+		resolve(name)
+	}
+
+	return resolved, errs
+}
+
+// executeField executes value as a template if it contains one.
+func executeField(field, value string, data TemplateData) (string, error) {
+	if !strings.Contains(value, "{{") {
+		return value, nil
+	}
+	t, err := template.New(field).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid %v template: %v", field, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed executing %v template: %v", field, err)
+	}
+
+	return buf.String(), nil
+}
+
+// executeList executes the templates in a list field. An element consisting
+// of a single action that yields a list, such as
+// "{{.Signer.Subject.OrganizationalUnit}}", is replaced by that list's
+// elements.
+func executeList(field string, values []string, data TemplateData) ([]string, error) {
+	var resolved []string
+	for _, value := range values {
+		if !strings.Contains(value, "{{") {
+			resolved = append(resolved, value)
+			continue
+		}
+		if list, ok := executeSpread(field, value, data); ok {
+			resolved = append(resolved, list...)
+			continue
+		}
+		v, err := executeField(field, value, data)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, v)
+	}
+
+	return resolved, nil
+}
+
+// executeSpread evaluates value, when it is a single action, as a list. It
+// reports false when value is not a single action or does not yield a list.
+func executeSpread(field, value string, data TemplateData) ([]string, bool) {
+	t, err := template.New(field).Parse(value)
+	if err != nil || len(t.Tree.Root.Nodes) != 1 {
+		return nil, false
+	}
+	action, ok := t.Tree.Root.Nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) > 0 {
+		return nil, false
+	}
+
+	// range fails on anything but lists, which is what tells them apart.
+	spread, err := template.New(field).Option("missingkey=error").Parse("{{range " + action.Pipe.String() + "}}{{.}}\x00{{end}}")
+	if err != nil {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := spread.Execute(&buf, data); err != nil {
+		return nil, false
+	}
+	list := strings.Split(buf.String(), "\x00")
+
+	return list[:len(list)-1], true
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveSubjects(t *testing.T) {
+	root := Cert{
+		Name:       "Root CA",
+		CommonName: "{{.Name}}",
+		IsCA:       true,
+		Subject: Subject{
+			Organization:       []string{"Acme Inc."},
+			OrganizationalUnit: []string{"IT"},
+			Country:            []string{"US"},
+		},
+	}
+	tests := []struct {
+		name string
+		defs []Cert
+		// want holds the resolved definitions to check, err the
+		// definitions expected to fail with a message containing the
+		// value.
+		want map[string]Cert
+		err  map[string]string
+	}{
+		{
+			name: "name",
+			defs: []Cert{
+				root,
+				{Name: "web", CommonName: "{{.Name}}.example.com", Signer: "Root CA", DNSNames: []string{"web.example.com"},
+					Subject: Subject{SerialNumber: "{{index .DNSNames 0}}"}},
+			},
+			want: map[string]Cert{
+				"Root CA": {CommonName: "Root CA", Subject: root.Subject},
+				"web":     {CommonName: "web.example.com", Subject: Subject{SerialNumber: "web.example.com"}},
+			},
+		},
+		{
+			name: "signer organizational unit along a chain",
+			defs: []Cert{
+				root,
+				{Name: "Issuing CA", CommonName: "{{.Name}}", Signer: "Root CA", IsCA: true,
+					Subject: Subject{OrganizationalUnit: []string{"{{.Signer.Subject.OrganizationalUnit}}", "PKI"}}},
+				{Name: "web", CommonName: "web", Signer: "Issuing CA",
+					Subject: Subject{OrganizationalUnit: []string{"{{.Signer.Subject.OrganizationalUnit}}", "Web"}}},
+			},
+			want: map[string]Cert{
+				"Issuing CA": {CommonName: "Issuing CA", Subject: Subject{OrganizationalUnit: []string{"IT", "PKI"}}},
+				"web":        {CommonName: "web", Subject: Subject{OrganizationalUnit: []string{"IT", "PKI", "Web"}}},
+			},
+		},
+		{
+			name: "self signed root referencing its signer",
+			defs: []Cert{
+				{Name: "Root CA", CommonName: "{{.Name}}", IsCA: true,
+					Subject: Subject{OrganizationalUnit: []string{"{{.Signer.Subject.OrganizationalUnit}}"}}},
+				{Name: "web", CommonName: "{{.Signer.CommonName}} web", Signer: "Root CA"},
+			},
+			err: map[string]string{"Root CA": "organizationalUnit"},
+			// Below a root that fails, its unresolved definition is used.
+			want: map[string]Cert{"web": {CommonName: "{{.Name}} web"}},
+		},
+		{
+			name: "inherit the fields left unset",
+			defs: []Cert{
+				root,
+				{Name: "web", CommonName: "web", Signer: "Root CA", InheritSubject: true,
+					Subject: Subject{Organization: []string{"Acme Web"}, Locality: []string{"Agloe"}}},
+				{Name: "mail", CommonName: "mail", Signer: "Root CA",
+					Subject: Subject{Organization: []string{"Acme Mail"}}},
+			},
+			want: map[string]Cert{
+				"web": {CommonName: "web", Subject: Subject{
+					Organization:       []string{"Acme Web"},
+					OrganizationalUnit: []string{"IT"},
+					Country:            []string{"US"},
+					Locality:           []string{"Agloe"},
+				}},
+				"mail": {CommonName: "mail", Subject: Subject{Organization: []string{"Acme Mail"}}},
+			},
+		},
+		{
+			name: "inherit the inherited fields",
+			defs: []Cert{
+				root,
+				{Name: "Issuing CA", CommonName: "{{.Name}}", Signer: "Root CA", IsCA: true, InheritSubject: true,
+					Subject: Subject{OrganizationalUnit: []string{"PKI"}}},
+				{Name: "web", CommonName: "web", Signer: "Issuing CA", InheritSubject: true},
+			},
+			want: map[string]Cert{
+				"web": {CommonName: "web", Subject: Subject{
+					Organization:       []string{"Acme Inc."},
+					OrganizationalUnit: []string{"PKI"},
+					Country:            []string{"US"},
+				}},
+			},
+		},
+		{
+			name: "missing field",
+			defs: []Cert{root, {Name: "web", CommonName: "{{.Nmae}}", Signer: "Root CA"}},
+			err:  map[string]string{"web": "commonName"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			byName := map[string]Cert{}
+			for _, def := range test.defs {
+				byName[def.Name] = def
+			}
+			resolved, errs := resolveSubjects(byName)
+			for name, want := range test.want {
+				got, ok := resolved[name]
+				if !ok {
+					t.Errorf("%v was not resolved: %v", name, errs[name])
+					continue
+				}
+				if got.CommonName != want.CommonName {
+					t.Errorf("%v commonName = %q, want %q", name, got.CommonName, want.CommonName)
+				}
+				if !reflect.DeepEqual(got.Subject, want.Subject) {
+					t.Errorf("%v subject = %+v, want %+v", name, got.Subject, want.Subject)
+				}
+			}
+			for name, want := range test.err {
+				if err := errs[name]; err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("%v error = %v, want one mentioning %v", name, err, want)
+				}
+			}
+			if len(errs) != len(test.err) {
+				t.Errorf("errors = %v, want %d", errs, len(test.err))
+			}
+		})
+	}
+}
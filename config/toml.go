@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// The TOML decoder does not report positions, definitions are located by
+// their table headers instead. Definitions written inline have no line.
+var (
+	tomlCertHeader    = regexp.MustCompile(`^\s*\[\[\s*certs\s*\]\]`)
+	tomlInclude       = regexp.MustCompile(`^\s*include\s*=`)
+	tomlProfileHeader = regexp.MustCompile(`^\s*\[\s*profiles\s*\.\s*(?:"([^"]*)"|'([^']*)'|([A-Za-z0-9_-]+))\s*\]`)
+	tomlVarsHeader    = regexp.MustCompile(`^\s*\[\s*vars\s*\]`)
+	tomlHeader        = regexp.MustCompile(`^\s*\[`)
+	tomlKey           = regexp.MustCompile(`^\s*(?:"([^"]*)"|'([^']*)'|([A-Za-z0-9_-]+))\s*=`)
+)
+
+// parseTOML parses a TOML configuration file. Certificates are [[certs]]
+// tables and profiles [profiles.name] tables.
+func parseTOML(f *fragment, b []byte) error {
+	var raw struct {
+		Include  interface{}               `toml:"include"`
+		Vars     map[string]interface{}    `toml:"vars"`
+		Profiles map[string]toml.Primitive `toml:"profiles"`
+		Certs    []toml.Primitive          `toml:"certs"`
+	}
+	md, err := toml.Decode(string(b), &raw)
+	if err != nil {
+		return fmt.Errorf("failed unmarshaling toml config (%v): %v", f.path, err)
+	}
+
+	var certLines []int
+	profileLines, varLines := map[string]int{}, map[string]int{}
+	inVars := false
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		if tomlCertHeader.Match(line) {
+			certLines = append(certLines, i+1)
+		} else if m := tomlProfileHeader.FindSubmatch(line); m != nil {
+			profileLines[string(m[1])+string(m[2])+string(m[3])] = i + 1
+		} else if f.includeLine == 0 && tomlInclude.Match(line) {
+			f.includeLine = i + 1
+		} else if m := tomlKey.FindSubmatch(line); m != nil && inVars {
+			varLines[string(m[1])+string(m[2])+string(m[3])] = i + 1
+		}
+		if tomlHeader.Match(line) {
+			inVars = tomlVarsHeader.Match(line)
+		}
+	}
+
+	for i, item := range raw.Certs {
+		line := 0
+		if i < len(certLines) {
+			line = certLines[i]
+		}
+		var named struct {
+			Name string `toml:"name"`
+		}
+		md.PrimitiveDecode(item, &named)
+		f.certs = append(f.certs, entry{name: named.Name, line: line, decode: tomlDecoder(md, item)})
+	}
+
+	var names []string
+	for name := range raw.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f.profiles = append(f.profiles, entry{name: name, line: profileLines[name], decode: tomlDecoder(md, raw.Profiles[name])})
+	}
+
+	names = nil
+	for name := range raw.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := scalarString(raw.Vars[name])
+		if err != nil {
+			f.fail("variable "+name, varLines[name], err)
+			continue
+		}
+		f.vars = append(f.vars, variable{name: name, value: value, line: varLines[name]})
+	}
+
+	switch include := raw.Include.(type) {
+	case nil:
+	case string:
+		f.include = []string{include}
+	case []interface{}:
+		for _, v := range include {
+			pattern, ok := v.(string)
+			if !ok {
+				f.fail("", f.includeLine, errors.New("include is not a glob or a list of globs"))
+				f.include = nil
+				break
+			}
+			f.include = append(f.include, pattern)
+		}
+	default:
+		f.fail("", f.includeLine, errors.New("include is not a glob or a list of globs"))
+	}
+
+	return nil
+}
+
+// tomlDecoder returns the decode function of the entry item. Entries
+// referring to variables are encoded again once expanded, which the decoder
+// needs to apply the types of v.
+func tomlDecoder(md toml.MetaData, item toml.Primitive) func(vars *variables, v interface{}) error {
+	return func(vars *variables, v interface{}) error {
+		var raw map[string]interface{}
+		if err := md.PrimitiveDecode(item, &raw); err != nil {
+			return err
+		}
+		expanded, changed, err := vars.expandValue(raw)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return md.PrimitiveDecode(item, v)
+		}
+
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(expanded); err != nil {
+			return err
+		}
+		_, err = toml.Decode(buf.String(), v)
+		return err
+	}
+}
+
+func decodeTOMLDocument(b []byte, doc *document) error {
+	var raw struct {
+		Include  interface{}               `toml:"include"`
+		Vars     map[string]interface{}    `toml:"vars"`
+		Profiles map[string]toml.Primitive `toml:"profiles"`
+		Certs    []toml.Primitive          `toml:"certs"`
+	}
+	md, err := toml.Decode(string(b), &raw)
+	if err != nil {
+		return err
+	}
+
+	doc.Include, doc.Vars = raw.Include, raw.Vars
+	for name, item := range raw.Profiles {
+		if doc.Profiles == nil {
+			doc.Profiles = map[string]interface{}{}
+		}
+		doc.Profiles[name] = tomlEntry(md, item, &Profile{})
+	}
+	for _, item := range raw.Certs {
+		doc.Certs = append(doc.Certs, tomlEntry(md, item, &Cert{}))
+	}
+
+	return nil
+}
+
+// tomlEntry decodes item into v, or into a map when it does not decode.
+func tomlEntry(md toml.MetaData, item toml.Primitive, v interface{}) interface{} {
+	if md.PrimitiveDecode(item, v) == nil {
+		return reflect.ValueOf(v).Elem().Interface()
+	}
+	var m map[string]interface{}
+	md.PrimitiveDecode(item, &m)
+	return m
+}
+
+func encodeTOMLDocument(doc *document) ([]byte, error) {
+	var buf strings.Builder
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+
+	return []byte(buf.String()), nil
+}
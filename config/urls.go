@@ -0,0 +1,81 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URLs are where relying parties find the revocation status of the
+// certificates a CA signs, and the CA certificate itself. They are stamped
+// into every certificate the CA signs.
+type URLs struct {
+	// Base lists the public URLs the API is served at, such as
+	// "https://pki.example.com/api". Each adds the CA's CRL, OCSP and
+	// certificate URLs under it.
+	Base []string `yaml:"base,omitempty" json:"base,omitempty" toml:"base,omitempty"`
+	// CRL, OCSP and Issuer add URLs served elsewhere, such as CRLs
+	// published to static hosting.
+	CRL    []string `yaml:"crl,omitempty" json:"crl,omitempty" toml:"crl,omitempty"`
+	OCSP   []string `yaml:"ocsp,omitempty" json:"ocsp,omitempty" toml:"ocsp,omitempty"`
+	Issuer []string `yaml:"issuer,omitempty" json:"issuer,omitempty" toml:"issuer,omitempty"`
+}
+
+// Paths of a CA's endpoints below a base URL.
+const (
+	crlPath    = "crl"
+	ocspPath   = "ocsp"
+	issuerPath = "file/cert.der"
+)
+
+// resolve returns the CRL distribution points, OCSP servers and issuing
+// certificate URLs of the certificates signed by ca.
+func (u *URLs) resolve(ca string) (crl, ocsp, issuer []string) {
+	if u == nil {
+		return nil, nil, nil
+	}
+	for _, base := range u.Base {
+		prefix := strings.TrimSuffix(base, "/") + "/" + url.PathEscape(ca) + "/"
+		crl = append(crl, prefix+crlPath)
+		ocsp = append(ocsp, prefix+ocspPath)
+		issuer = append(issuer, prefix+issuerPath)
+	}
+
+	return append(crl, u.CRL...), append(ocsp, u.OCSP...), append(issuer, u.Issuer...)
+}
+
+// apply stamps the URLs of the certificates signed by ca into tmpl.
+func (u *URLs) apply(tmpl *x509.Certificate, ca string) {
+	tmpl.CRLDistributionPoints, tmpl.OCSPServer, tmpl.IssuingCertificateURL = u.resolve(ca)
+}
+
+// validate checks that every URL is absolute.
+func (u *URLs) validate() error {
+	if u == nil {
+		return nil
+	}
+	for _, urls := range [][]string{u.Base, u.CRL, u.OCSP, u.Issuer} {
+		for _, s := range urls {
+			parsed, err := url.Parse(s)
+			if err != nil {
+				return fmt.Errorf("invalid URL %q: %v", s, err)
+			}
+			if !parsed.IsAbs() || parsed.Host == "" {
+				return fmt.Errorf("URL %q is not absolute", s)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameURLs reports whether issued carries the URLs of the certificates signed
+// by ca.
+func sameURLs(issued *x509.Certificate, u *URLs, ca string) bool {
+	crl, ocsp, issuer := u.resolve(ca)
+
+	return sameSet(issued.CRLDistributionPoints, crl) &&
+		sameSet(issued.OCSPServer, ocsp) &&
+		sameSet(issued.IssuingCertificateURL, issuer)
+}
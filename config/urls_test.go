@@ -0,0 +1,62 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestURLsResolve(t *testing.T) {
+	tests := []struct {
+		desc              string
+		urls              *URLs
+		ca                string
+		crl, ocsp, issuer []string
+	}{{
+		desc: "none",
+		ca:   "Root CA",
+	}, {
+		desc:   "base",
+		urls:   &URLs{Base: []string{"https://pki.acme.internal/api/", "http://pki.acme.internal/api"}},
+		ca:     "Root CA",
+		crl:    []string{"https://pki.acme.internal/api/Root%20CA/crl", "http://pki.acme.internal/api/Root%20CA/crl"},
+		ocsp:   []string{"https://pki.acme.internal/api/Root%20CA/ocsp", "http://pki.acme.internal/api/Root%20CA/ocsp"},
+		issuer: []string{"https://pki.acme.internal/api/Root%20CA/file/cert.der", "http://pki.acme.internal/api/Root%20CA/file/cert.der"},
+	}, {
+		desc: "base and explicit",
+		urls: &URLs{
+			Base:   []string{"https://pki.acme.internal/api"},
+			CRL:    []string{"http://crl.acme.internal/root.crl"},
+			Issuer: []string{"http://crl.acme.internal/root.der"},
+		},
+		ca:     "root/ca",
+		crl:    []string{"https://pki.acme.internal/api/root%2Fca/crl", "http://crl.acme.internal/root.crl"},
+		ocsp:   []string{"https://pki.acme.internal/api/root%2Fca/ocsp"},
+		issuer: []string{"https://pki.acme.internal/api/root%2Fca/file/cert.der", "http://crl.acme.internal/root.der"},
+	}}
+
+	for _, test := range tests {
+		crl, ocsp, issuer := test.urls.resolve(test.ca)
+		if !reflect.DeepEqual(crl, test.crl) || !reflect.DeepEqual(ocsp, test.ocsp) || !reflect.DeepEqual(issuer, test.issuer) {
+			t.Errorf("%v: resolve = %q, %q, %q; want %q, %q, %q", test.desc, crl, ocsp, issuer, test.crl, test.ocsp, test.issuer)
+		}
+	}
+}
+
+func TestURLsValidate(t *testing.T) {
+	tests := []struct {
+		urls *URLs
+		ok   bool
+	}{
+		{nil, true},
+		{&URLs{Base: []string{"https://pki.acme.internal/api"}, OCSP: []string{"http://ocsp.acme.internal"}}, true},
+		{&URLs{Base: []string{"/api"}}, false},
+		{&URLs{CRL: []string{"pki.acme.internal/root.crl"}}, false},
+		{&URLs{OCSP: []string{"file:///ocsp"}}, false},
+		{&URLs{Issuer: []string{"http://pki.acme.internal/%zz"}}, false},
+	}
+	for _, test := range tests {
+		if err := test.urls.validate(); (err == nil) != test.ok {
+			t.Errorf("validate(%+v) = %v; want ok %v", test.urls, err, test.ok)
+		}
+	}
+}
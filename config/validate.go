@@ -0,0 +1,287 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidationError is a problem with a single certificate definition.
+type ValidationError struct {
+	Name   string
+	Source Source
+	Msg    string
+}
+
+func (e ValidationError) Error() string {
+	msg := e.Msg
+	if e.Name != "" {
+		msg = fmt.Sprintf("%v: %v", e.Name, msg)
+	}
+	if src := e.Source.String(); src != "" {
+		msg = fmt.Sprintf("%v: %v", src, msg)
+	}
+
+	return msg
+}
+
+// ValidationErrors lists every problem found in a configuration.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d invalid certificate definitions:\n\t%v", len(e), strings.Join(msgs, "\n\t"))
+}
+
+// Validate checks the definitions held by s. Problems with the definitions
+// are returned as ValidationErrors, failing to read them as any other error.
+func Validate(s Store) error {
+	certs, err := s.List(Filter{})
+	if err != nil {
+		return err
+	}
+	profiles, err := storeProfiles(s)
+	if err != nil {
+		return err
+	}
+
+	if errs := validateCerts(certs, profiles); len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// reservedCANames are API routes a CA of that name would be shadowed by,
+// and reservedNames the routes below a CA a certificate it signs would be.
+var (
+	reservedCANames = map[string]bool{"certificates": true, "plan": true, "schema": true, "status": true}
+	reservedNames   = map[string]bool{"crl": true, "crl.pem": true, "ocsp": true}
+)
+
+func validateCerts(certs []Cert, profiles map[string]Profile) ValidationErrors {
+	var errs ValidationErrors
+	fail := func(cert Cert, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Name: cert.Name, Source: cert.Source, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	byName := map[string]Cert{}
+	resolved := map[string]Profile{}
+	for _, cert := range certs {
+		if cert.Name == "" {
+			fail(cert, "name is empty")
+			continue
+		}
+		if first, ok := byName[cert.Name]; ok {
+			if src := first.Source.String(); src != "" {
+				fail(cert, "duplicate name, first defined at %v", src)
+			} else {
+				fail(cert, "duplicate name")
+			}
+			continue
+		}
+		byName[cert.Name] = cert
+		if cert.IsCA && reservedCANames[cert.Name] || !selfSigned(cert) && reservedNames[cert.Name] {
+			fail(cert, "name %v is reserved for the API", cert.Name)
+		}
+		if p, err := resolveProfile(cert, profiles); err != nil {
+			fail(cert, "%v", err)
+		} else {
+			resolved[cert.Name] = p
+		}
+	}
+	subjects, subjectErrs := resolveSubjects(byName)
+
+	for _, cert := range certs {
+		commonName := cert.CommonName
+		if s, ok := subjects[cert.Name]; ok {
+			commonName = s.CommonName
+		}
+		if err := subjectErrs[cert.Name]; err != nil {
+			fail(cert, "%v", err)
+		} else if commonName == "" {
+			fail(cert, "commonName is empty")
+		}
+		if p, ok := resolved[cert.Name]; ok && p.Expire <= 0 && cert.NotAfter == nil {
+			fail(cert, "expire must be a positive duration, got %v", p.Expire)
+		}
+		if cert.NotAfter != nil && cert.Expire != 0 {
+			fail(cert, "set either expire or notAfter, not both")
+		}
+		if cert.NotBefore != nil && cert.NotAfter != nil && !cert.NotAfter.After(*cert.NotBefore) {
+			fail(cert, "notAfter %v is not after notBefore %v", cert.NotAfter.Format(time.RFC3339), cert.NotBefore.Format(time.RFC3339))
+		}
+		if cert.Backdate < 0 {
+			fail(cert, "backdate cannot be negative, got %v", cert.Backdate)
+		}
+		if cert.CRLExpire != 0 {
+			if !cert.IsCA {
+				fail(cert, "only CAs can have a crlExpire")
+			} else if cert.CRLExpire < 0 {
+				fail(cert, "crlExpire cannot be negative, got %v", cert.CRLExpire)
+			}
+		}
+		if cert.DelegatedOCSP && !cert.IsCA {
+			fail(cert, "only CAs can have a delegatedOCSP")
+		}
+		if len(cert.SignProfiles) > 0 && !cert.IsCA {
+			fail(cert, "only CAs can have signProfiles")
+		}
+		for _, name := range cert.SignProfiles {
+			if p, ok := profiles[name]; !ok {
+				fail(cert, "sign profile %v is not defined", name)
+			} else if p.IsCA {
+				fail(cert, "sign profile %v is a CA profile, requests for CAs cannot be signed", name)
+			}
+		}
+		if cert.MaxPathLen != nil {
+			if !cert.IsCA {
+				fail(cert, "only CAs can have a maxPathLen")
+			} else if *cert.MaxPathLen < 0 {
+				fail(cert, "maxPathLen cannot be negative, got %d", *cert.MaxPathLen)
+			}
+		}
+		if cert.CSR != "" {
+			if cert.IsCA {
+				fail(cert, "CAs cannot be issued for a certificate request")
+			} else if _, err := requestKey(cert); err != nil {
+				fail(cert, "%v", err)
+			}
+		} else if _, _, err := keyParams(cert); err != nil {
+			fail(cert, "%v", err)
+		}
+		if _, err := parseIPs(cert.IPAddresses); err != nil {
+			fail(cert, "%v", err)
+		}
+		if _, err := parseURIs(cert.URIs); err != nil {
+			fail(cert, "%v", err)
+		}
+		if nc := cert.NameConstraints; nc != nil {
+			if !cert.IsCA {
+				fail(cert, "only CAs can have nameConstraints")
+			} else if err := nc.apply(&x509.Certificate{}); err != nil {
+				fail(cert, "%v", err)
+			}
+		}
+
+		if _, err := extraExtensions(cert); err != nil {
+			fail(cert, "%v", err)
+		}
+		if cert.URLs != nil {
+			if !cert.IsCA {
+				fail(cert, "only CAs can have urls")
+			} else if err := cert.URLs.validate(); err != nil {
+				fail(cert, "%v", err)
+			}
+		}
+
+		if cert.Imported {
+			if !cert.IsCA {
+				fail(cert, "only CAs can be imported")
+			} else if cert.External {
+				fail(cert, "a CA cannot be both imported and externally signed")
+			}
+			// The signer of an imported CA may be outside of the PKI.
+			if _, ok := byName[cert.Signer]; !ok {
+				continue
+			}
+		}
+		if cert.External {
+			if !cert.IsCA {
+				fail(cert, "only CAs can be externally signed")
+			} else if _, ok := byName[cert.Signer]; ok && !selfSigned(cert) {
+				fail(cert, "externally signed CA cannot have the configured signer %v", cert.Signer)
+			}
+			continue
+		}
+		if selfSigned(cert) {
+			if !cert.IsCA {
+				fail(cert, "only CAs can be self signed")
+			}
+			continue
+		}
+		signer, ok := byName[cert.Signer]
+		if !ok {
+			fail(cert, "signer %v is not defined", cert.Signer)
+			continue
+		}
+		if !signer.IsCA {
+			fail(cert, "signer %v is not a CA", cert.Signer)
+		}
+		if p, ok := resolved[signer.Name]; ok && cert.IsCA && p.MaxPathLen != nil && *p.MaxPathLen == 0 {
+			fail(cert, "signer %v has maxPathLen 0 and cannot sign CAs", cert.Signer)
+		}
+		// Issuing clamps whatever still outlives the signer, these are only
+		// the definitions that always would.
+		p, ok := resolved[cert.Name]
+		sp, sok := resolved[signer.Name]
+		switch {
+		case !ok || !sok:
+		case cert.NotAfter == nil && signer.NotAfter == nil && cert.NotBefore == nil && signer.NotBefore == nil:
+			if p.Expire > sp.Expire && sp.Expire > 0 {
+				fail(cert, "expire %v outlives signer %v which expires after %v", p.Expire, signer.Name, sp.Expire)
+			}
+		case cert.NotAfter != nil && signer.NotAfter != nil:
+			if cert.NotAfter.After(*signer.NotAfter) {
+				fail(cert, "notAfter %v outlives signer %v which expires at %v", cert.NotAfter.Format(time.RFC3339), signer.Name, signer.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+
+	for _, cert := range certs {
+		if cycle := signerCycle(byName, cert); cycle != nil && cycle[0] == cert.Name {
+			fail(cert, "signer cycle %v", strings.Join(cycle, " -> "))
+		}
+	}
+
+	errs.sort()
+
+	return errs
+}
+
+// sort orders the errors by where the definitions are.
+func (e ValidationErrors) sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		if e[i].Source.File != e[j].Source.File {
+			return e[i].Source.File < e[j].Source.File
+		}
+		return e[i].Source.Line < e[j].Source.Line
+	})
+}
+
+// signerCycle follows the signers of cert and returns the cycle it leads
+// into, starting and ending with the smallest name in it, or nil.
+func signerCycle(byName map[string]Cert, cert Cert) []string {
+	var path []string
+	seen := map[string]int{}
+	for {
+		if i, ok := seen[cert.Name]; ok {
+			cycle := path[i:]
+			start := 0
+			for j, name := range cycle {
+				if name < cycle[start] {
+					start = j
+				}
+			}
+			cycle = append(append(append([]string(nil), cycle[start:]...), cycle[:start]...), cycle[start])
+			return cycle
+		}
+		if selfSigned(cert) {
+			return nil
+		}
+		seen[cert.Name] = len(path)
+		path = append(path, cert.Name)
+
+		next, ok := byName[cert.Signer]
+		if !ok {
+			return nil
+		}
+		cert = next
+	}
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateCerts(t *testing.T) {
+	year := Duration(365 * 24 * time.Hour)
+	at := func(s string) *time.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &ts
+	}
+	zero := 0
+	root := Cert{Name: "Root CA", CommonName: "Root CA", IsCA: true, Expire: 10 * year}
+	leaf := func(edit func(*Cert)) Cert {
+		c := Cert{Name: "web", CommonName: "web.acme.internal", Signer: "Root CA", Expire: year}
+		if edit != nil {
+			edit(&c)
+		}
+		return c
+	}
+	profiles := mergeProfiles(map[string]Profile{"short": {Expire: Duration(24 * time.Hour)}})
+
+	tests := []struct {
+		desc  string
+		certs []Cert
+		want  []string
+	}{{
+		desc:  "valid",
+		certs: []Cert{root, leaf(nil), leaf(func(c *Cert) { c.Name = "api"; c.Expire = 0; c.Profile = "short" })},
+	}, {
+		desc:  "empty name",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Name = "" })},
+		want:  []string{"name is empty"},
+	}, {
+		desc:  "duplicate name",
+		certs: []Cert{root, leaf(nil), leaf(nil)},
+		want:  []string{"web: duplicate name"},
+	}, {
+		desc:  "empty commonName",
+		certs: []Cert{root, leaf(func(c *Cert) { c.CommonName = "" })},
+		want:  []string{"web: commonName is empty"},
+	}, {
+		desc:  "unknown profile",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Profile = "long" })},
+		want:  []string{"web: profile long is not defined"},
+	}, {
+		desc:  "expire outlives signer",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Expire = 11 * year })},
+		want:  []string{"web: expire 96360h0m0s outlives signer Root CA which expires after 87600h0m0s"},
+	}, {
+		desc:  "expire of signer",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Expire = 10 * year })},
+	}, {
+		desc: "notAfter outlives signer",
+		certs: []Cert{
+			{Name: "Root CA", CommonName: "Root CA", IsCA: true, NotAfter: at("2030-01-01T00:00:00Z")},
+			leaf(func(c *Cert) { c.Expire = 0; c.NotAfter = at("2031-01-01T00:00:00Z") }),
+		},
+		want: []string{"web: notAfter 2031-01-01T00:00:00Z outlives signer Root CA which expires at 2030-01-01T00:00:00Z"},
+	}, {
+		// Only issuing can tell, and clamps it.
+		desc:  "notAfter with a signer expire",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Expire = 0; c.NotAfter = at("2100-01-01T00:00:00Z") })},
+	}, {
+		desc:  "expire and notAfter",
+		certs: []Cert{root, leaf(func(c *Cert) { c.NotAfter = at("2030-01-01T00:00:00Z") })},
+		want:  []string{"web: set either expire or notAfter, not both"},
+	}, {
+		desc: "notAfter before notBefore",
+		certs: []Cert{root, leaf(func(c *Cert) {
+			c.Expire = 0
+			c.NotBefore = at("2030-01-01T00:00:00Z")
+			c.NotAfter = at("2029-01-01T00:00:00Z")
+		})},
+		want: []string{"web: notAfter 2029-01-01T00:00:00Z is not after notBefore 2030-01-01T00:00:00Z"},
+	}, {
+		desc:  "negative backdate",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Backdate = -Duration(time.Hour) })},
+		want:  []string{"web: backdate cannot be negative, got -1h0m0s"},
+	}, {
+		desc:  "maxPathLen of a leaf",
+		certs: []Cert{root, leaf(func(c *Cert) { c.MaxPathLen = &zero })},
+		want:  []string{"web: only CAs can have a maxPathLen"},
+	}, {
+		desc: "CA below maxPathLen 0",
+		certs: []Cert{
+			{Name: "Root CA", CommonName: "Root CA", IsCA: true, Expire: 10 * year, MaxPathLen: &zero},
+			leaf(func(c *Cert) { c.IsCA = true }),
+		},
+		want: []string{"web: signer Root CA has maxPathLen 0 and cannot sign CAs"},
+	}, {
+		desc:  "revocation settings of a leaf",
+		certs: []Cert{root, leaf(func(c *Cert) { c.CRLExpire = Duration(time.Hour); c.DelegatedOCSP = true })},
+		want:  []string{"web: only CAs can have a crlExpire", "web: only CAs can have a delegatedOCSP"},
+	}, {
+		desc:  "name of a route below the signer",
+		certs: []Cert{root, leaf(func(c *Cert) { c.Name = "ocsp" }), leaf(func(c *Cert) { c.Name = "crl.pem" })},
+		want:  []string{"ocsp: name ocsp is reserved for the API", "crl.pem: name crl.pem is reserved for the API"},
+	}, {
+		desc:  "name of a top level route",
+		certs: []Cert{{Name: "status", CommonName: "Status CA", IsCA: true, Expire: year}, leaf(func(c *Cert) { c.Name = "plan"; c.Signer = "status" })},
+		want:  []string{"status: name status is reserved for the API"},
+	}, {
+		desc: "signProfiles",
+		certs: []Cert{
+			{Name: "Root CA", CommonName: "Root CA", IsCA: true, Expire: 10 * year, SignProfiles: []string{"tls-server", "long", "ca"}},
+			leaf(func(c *Cert) { c.SignProfiles = []string{"tls-server"} }),
+		},
+		want: []string{
+			"Root CA: sign profile long is not defined",
+			"Root CA: sign profile ca is a CA profile, requests for CAs cannot be signed",
+			"web: only CAs can have signProfiles",
+		},
+	}, {
+		desc:  "undefined signer",
+		certs: []Cert{leaf(nil)},
+		want:  []string{"web: signer Root CA is not defined"},
+	}, {
+		desc:  "signer not a CA",
+		certs: []Cert{root, leaf(nil), leaf(func(c *Cert) { c.Name = "api"; c.Signer = "web" })},
+		want:  []string{"api: signer web is not a CA"},
+	}, {
+		desc:  "self signed leaf",
+		certs: []Cert{leaf(func(c *Cert) { c.Signer = "" })},
+		want:  []string{"web: only CAs can be self signed"},
+	}, {
+		desc: "signer cycle",
+		certs: []Cert{
+			{Name: "A", CommonName: "A", IsCA: true, Signer: "B", Expire: year},
+			{Name: "B", CommonName: "B", IsCA: true, Signer: "A", Expire: year},
+		},
+		want: []string{"A: signer cycle A -> B -> A"},
+	}}
+
+	for _, test := range tests {
+		var got []string
+		for _, err := range validateCerts(test.certs, profiles) {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: validateCerts = %q; want %q", test.desc, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// window returns the validity of cert issued at now with its resolved
+// profile p, before clamping it to the signer. An absolute notBefore replaces
+// both the issue time and the backdate, and an absolute notAfter the expire.
+func window(cert Cert, p Profile, now time.Time) (time.Time, time.Time) {
+	start, notBefore := now, now.Add(-time.Duration(p.Backdate))
+	if cert.NotBefore != nil {
+		start, notBefore = *cert.NotBefore, *cert.NotBefore
+	}
+
+	notAfter := start.Add(time.Duration(p.Expire))
+	if cert.NotAfter != nil {
+		notAfter = *cert.NotAfter
+	}
+
+	return notBefore, notAfter
+}
+
+// clamp returns notAfter shortened so the certificate does not outlive its
+// signer, and whether it had to be shortened.
+func clamp(notAfter time.Time, signer *x509.Certificate) (time.Time, bool) {
+	if signer != nil && notAfter.After(signer.NotAfter) {
+		return signer.NotAfter, true
+	}
+
+	return notAfter, false
+}
+
+// pathLen returns the path length a CA issued with profile p by signer gets,
+// -1 meaning unlimited. CAs below a path length constrained CA are
+// constrained further.
+func pathLen(p Profile, signer *x509.Certificate) int {
+	n := -1
+	if p.MaxPathLen != nil {
+		n = *p.MaxPathLen
+	}
+	if signer != nil && signer.MaxPathLen > 0 && (n < 0 || n >= signer.MaxPathLen) {
+		n = signer.MaxPathLen - 1
+	}
+
+	return n
+}
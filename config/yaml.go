@@ -1,37 +1,785 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"github.com/go-yaml/yaml"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
-)
+	"strings"
+	"sync"
 
-type config struct {
-	Certs []Cert `yaml:"certs"`
-}
+	"gopkg.in/yaml.v3"
+)
 
+// Yaml is a Store backed by configuration files, in YAML, JSON or TOML. The
+// parsed definitions are kept in memory; changes made to the files by
+// anything but the store itself are only picked up by Load and Swap, which
+// the Reloader takes care of.
 type Yaml struct {
 	Path string
+	// Format is the format of the file at Path, one of FormatYAML,
+	// FormatJSON or FormatTOML. When empty, and for any other file, the
+	// format is picked by extension.
+	Format string
+
+	mu   sync.RWMutex
+	defs *Definitions
 }
 
 type certsBySigner map[string][]Cert
 
+// definitions returns the definitions the store currently serves, loading
+// them on first use. Like modify, it treats a file that does not exist yet as
+// empty.
+func (y *Yaml) definitions() (*Definitions, error) {
+	y.mu.RLock()
+	defs := y.defs
+	y.mu.RUnlock()
+
+	if defs == nil {
+		if _, err := os.Stat(y.Path); os.IsNotExist(err) {
+			return &Definitions{}, nil
+		}
+		var err error
+		if defs, err = y.Load(); err != nil {
+			return nil, err
+		}
+		y.Swap(defs)
+	}
+
+	return defs, nil
+}
+
+// readConfig returns the certificates the store currently serves.
 func (y *Yaml) readConfig() ([]Cert, error) {
-	b, err := ioutil.ReadFile(y.Path)
+	defs, err := y.definitions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers may reorder the result, keep the served slice intact.
+	return append([]Cert(nil), defs.Certs...), nil
+}
+
+// Profiles returns the profiles defined in the profiles section of the file.
+func (y *Yaml) Profiles() (map[string]Profile, error) {
+	defs, err := y.definitions()
+	if err != nil {
+		return nil, err
+	}
+
+	return defs.Profiles, nil
+}
+
+// Swap replaces the definitions the store serves, as returned by Load.
+func (y *Yaml) Swap(defs *Definitions) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+
+	y.defs = defs
+}
+
+// Load parses the configuration. Path is either a file or a directory whose
+// .yml, .yaml, .json and .toml files are read in name order, and every file
+// can pull in more with include globs relative to itself. References to
+// variables are resolved once every file is read. Unlike the Store methods it
+// always reads the files, and it does not change what the store serves; see
+// Swap.
+func (y *Yaml) Load() (*Definitions, error) {
+	l := &yamlLoader{format: y.format, defs: &Definitions{}, loaded: map[string]bool{}, vars: &variables{vars: map[string]string{}}, varSources: map[string]Source{}}
+	if err := l.loadPath(y.Path); err != nil {
+		return nil, err
+	}
+	l.decode()
+	if len(l.errs) > 0 {
+		l.errs.sort()
+		return nil, l.errs
+	}
+
+	return l.defs, nil
+}
+
+// yamlLoader merges the files making up a configuration.
+type yamlLoader struct {
+	format func(path string) string
+	defs   *Definitions
+	errs   ValidationErrors
+	// loaded holds the files read so far, so files included twice, or
+	// including each other, are read once.
+	loaded    map[string]bool
+	fragments []*fragment
+	vars      *variables
+	// varSources records where each variable was defined.
+	varSources map[string]Source
+}
+
+// loadPath reads the file, or the fragments in the directory, at path.
+func (l *yamlLoader) loadPath(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed reading configuration file %v: %v", path, err)
+	}
+	if !fi.IsDir() {
+		return l.loadFile(path)
+	}
+
+	l.defs.Sources = append(l.defs.Sources, path)
+	files, err := fragments(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := l.loadFile(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *yamlLoader) loadFile(path string) error {
+	if l.loaded[filepath.Clean(path)] {
+		return nil
+	}
+	l.loaded[filepath.Clean(path)] = true
+	l.defs.Sources = append(l.defs.Sources, path)
+
+	f, err := parseFragment(path, l.format(path))
+	if err != nil {
+		return err
+	}
+	l.fragments = append(l.fragments, f)
+
+	// Variables are defaults for the environment and can only refer to it.
+	env := &variables{}
+	for _, v := range f.vars {
+		name := "variable " + v.name
+		if !varName.MatchString(v.name) {
+			f.fail(name, v.line, fmt.Errorf("invalid variable name %q", v.name))
+			continue
+		}
+		if first, ok := l.varSources[v.name]; ok {
+			f.fail(name, v.line, fmt.Errorf("duplicate variable, first defined at %v", first))
+			continue
+		}
+		value, err := env.expand(v.value)
+		if err == nil {
+			err = env.check()
+		}
+		if err != nil {
+			f.fail(name, v.line, err)
+			continue
+		}
+		l.varSources[v.name] = Source{File: path, Line: v.line}
+		l.vars.vars[v.name] = value
+	}
+
+	for _, pattern := range f.include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			f.fail("", f.includeLine, fmt.Errorf("invalid include %v: %v", pattern, err))
+			continue
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			f.fail("", f.includeLine, fmt.Errorf("included file %v does not exist", pattern))
+		}
+		for _, m := range matches {
+			if err := l.loadPath(m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decode decodes the entries of every file read, now that all variables are
+// known.
+func (l *yamlLoader) decode() {
+	profiles := map[string]Source{}
+	for _, f := range l.fragments {
+		for _, e := range f.certs {
+			var cert Cert
+			if err := l.decodeEntry(e, &cert); err != nil {
+				f.fail(e.name, e.line, err)
+				continue
+			}
+			cert.Source = Source{File: f.path, Line: e.line}
+			l.defs.Certs = append(l.defs.Certs, cert)
+		}
+
+		for _, e := range f.profiles {
+			var p Profile
+			if err := l.decodeEntry(e, &p); err != nil {
+				f.fail("profile "+e.name, e.line, err)
+				continue
+			}
+			if first, ok := profiles[e.name]; ok {
+				f.fail("profile "+e.name, e.line, fmt.Errorf("duplicate profile, first defined at %v", first))
+				continue
+			}
+			if l.defs.Profiles == nil {
+				l.defs.Profiles = map[string]Profile{}
+			}
+			profiles[e.name] = Source{File: f.path, Line: e.line}
+			l.defs.Profiles[e.name] = p
+		}
+
+		l.errs = append(l.errs, f.errs...)
+	}
+}
+
+// decodeEntry decodes e into v. References to undefined variables are
+// reported rather than the errors decoding their empty values causes.
+func (l *yamlLoader) decodeEntry(e entry, v interface{}) error {
+	err := e.decode(l.vars, v)
+	if undefined := l.vars.check(); undefined != nil {
+		return undefined
+	}
+
+	return err
+}
+
+// parseYAML parses a YAML configuration file. Entries are decoded one by one
+// so every broken entry is reported with its position, instead of only the
+// first one.
+func parseYAML(f *fragment, b []byte) error {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return fmt.Errorf("failed umarshaling yaml config (%v): %v", f.path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	certs, err := certsNode(doc)
+	if err != nil {
+		return fmt.Errorf("invalid yaml config (%v): %v", f.path, err)
+	}
+
+	for _, item := range certs.Content {
+		f.certs = append(f.certs, entry{name: scalarValue(item, "name"), line: item.Line, decode: yamlDecoder(item)})
+	}
+
+	profiles := mappingValue(doc.Content[0], "profiles")
+	switch {
+	case profiles == nil || profiles.Tag == "!!null":
+	case profiles.Kind != yaml.MappingNode:
+		f.fail("", profiles.Line, errors.New("profiles is not a mapping"))
+	default:
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			name, item := profiles.Content[i].Value, profiles.Content[i+1]
+			f.profiles = append(f.profiles, entry{name: name, line: item.Line, decode: yamlDecoder(item)})
+		}
+	}
+
+	vars := mappingValue(doc.Content[0], "vars")
+	switch {
+	case vars == nil || vars.Tag == "!!null":
+	case vars.Kind != yaml.MappingNode:
+		f.fail("", vars.Line, errors.New("vars is not a mapping"))
+	default:
+		for i := 0; i+1 < len(vars.Content); i += 2 {
+			name, item := vars.Content[i].Value, vars.Content[i+1]
+			if item.Kind != yaml.ScalarNode {
+				f.fail("variable "+name, item.Line, errors.New("value is not a scalar"))
+				continue
+			}
+			f.vars = append(f.vars, variable{name: name, value: item.Value, line: item.Line})
+		}
+	}
+
+	include := mappingValue(doc.Content[0], "include")
+	switch {
+	case include == nil || include.Tag == "!!null":
+	case include.Kind == yaml.ScalarNode:
+		f.include, f.includeLine = []string{include.Value}, include.Line
+	case include.Kind == yaml.SequenceNode && include.Decode(&f.include) == nil:
+		f.includeLine = include.Line
+	default:
+		f.fail("", include.Line, errors.New("include is not a glob or a list of globs"))
+	}
+
+	return nil
+}
+
+// yamlDecoder returns the decode function of the entry at n.
+func yamlDecoder(n *yaml.Node) func(vars *variables, v interface{}) error {
+	return func(vars *variables, v interface{}) error {
+		n, err := vars.expandNode(n)
+		if err != nil {
+			return err
+		}
+		return n.Decode(v)
+	}
+}
+
+// fragments returns the configuration files in dir, in name order. Hidden
+// files, such as the store's temporary files, are skipped.
+func fragments(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed reading configuration file %v: %v", y.Path, err)
+		return nil, fmt.Errorf("failed reading configuration directory %v: %v", dir, err)
+	}
+
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if configExtension(e.Name()) {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
 	}
-	conf := &config{}
-	if err := yaml.Unmarshal(b, conf); err != nil {
-		return nil, fmt.Errorf("failed umarshaling yaml config (%v) %v: %v", y.Path, string(b), err)
+
+	return files, nil
+}
+
+// mappingValue returns the node stored under key in the mapping node n, or
+// nil.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
 	}
 
-	return conf.Certs, nil
+	return nil
 }
 
+// scalarValue returns the value of key in the mapping node n, if it is a
+// scalar.
+func scalarValue(n *yaml.Node, key string) string {
+	if v := mappingValue(n, key); v != nil && v.Kind == yaml.ScalarNode {
+		return v.Value
+	}
+
+	return ""
+}
+
+// Add appends cert to the certs list of its signer's file, or of the main
+// configuration file. Files are rewritten atomically; YAML files keep their
+// comments and anchors.
 func (y *Yaml) Add(cert Cert) error {
-	return fmt.Errorf("not implmented")
+	if cert.Name == "" {
+		return fmt.Errorf("cannot add a certificate without a name")
+	}
+
+	return y.modify(func(defs *Definitions) error {
+		if findCert(defs.Certs, cert.Name) != nil {
+			return fmt.Errorf("cannot add %v: %w", cert.Name, ErrExists)
+		}
+
+		path, err := y.fileFor(defs, cert)
+		if err != nil {
+			return err
+		}
+		return editCerts(path, y.format(path), func(certs certList) error {
+			return certs.add(cert)
+		})
+	})
+}
+
+// Update replaces the definition of name with cert, in the file it is
+// defined in. Comments attached to the replaced entry are kept.
+func (y *Yaml) Update(name string, cert Cert) error {
+	if cert.Name == "" {
+		return fmt.Errorf("cannot update %v to a certificate without a name", name)
+	}
+
+	return y.modify(func(defs *Definitions) error {
+		old := findCert(defs.Certs, name)
+		if old == nil {
+			return fmt.Errorf("cannot update %v: %w", name, ErrNotFound)
+		}
+		if cert.Name != name {
+			if findCert(defs.Certs, cert.Name) != nil {
+				return fmt.Errorf("cannot rename %v to %v: %w", name, cert.Name, ErrExists)
+			}
+			if len(signedBy(defs.Certs, name)) > 0 {
+				return fmt.Errorf("cannot rename %v: %w", name, ErrHasChildren)
+			}
+		}
+
+		path := old.Source.File
+		return editCerts(path, y.format(path), func(certs certList) error {
+			i, err := certs.index(name)
+			if err != nil {
+				return err
+			}
+			if i < 0 {
+				return fmt.Errorf("cannot update %v: %w", name, ErrNotFound)
+			}
+
+			return certs.replace(i, cert)
+		})
+	})
+}
+
+// Delete removes the definition of name, and with cascade everything it
+// signs directly or indirectly, from whichever files define them.
+func (y *Yaml) Delete(name string, cascade bool) error {
+	return y.modify(func(defs *Definitions) error {
+		if findCert(defs.Certs, name) == nil {
+			return fmt.Errorf("cannot delete %v: %w", name, ErrNotFound)
+		}
+
+		below := descendants(defs.Certs, name)
+		if len(below) > 0 && !cascade {
+			return fmt.Errorf("cannot delete %v: %w", name, ErrHasChildren)
+		}
+		remove := map[string]bool{name: true}
+		for _, n := range below {
+			remove[n] = true
+		}
+
+		var files []string
+		for _, cert := range defs.Certs {
+			if remove[cert.Name] && !contains(files, cert.Source.File) {
+				files = append(files, cert.Source.File)
+			}
+		}
+		for _, path := range files {
+			if err := editCerts(path, y.format(path), func(certs certList) error {
+				return certs.remove(remove)
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// findCert returns the named definition, or nil.
+func findCert(certs []Cert, name string) *Cert {
+	for i := range certs {
+		if certs[i].Name == name {
+			return &certs[i]
+		}
+	}
+
+	return nil
+}
+
+// format returns the format of the configuration file at path.
+func (y *Yaml) format(path string) string {
+	if y.Format != "" && filepath.Clean(path) == filepath.Clean(y.Path) {
+		return y.Format
+	}
+
+	return fileFormat(path)
+}
+
+// fileFor returns the file a new definition is added to: its signer's, or
+// the main configuration file.
+func (y *Yaml) fileFor(defs *Definitions, cert Cert) (string, error) {
+	if signer := findCert(defs.Certs, cert.Signer); signer != nil && !selfSigned(cert) {
+		return signer.Source.File, nil
+	}
+
+	fi, err := os.Stat(y.Path)
+	if err != nil || !fi.IsDir() {
+		return y.Path, nil
+	}
+	files, err := fragments(y.Path)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return filepath.Join(y.Path, "pki.yml"), nil
+	}
+
+	return files[0], nil
+}
+
+// List returns the definitions matching filter, ordered by name.
+func (y *Yaml) List(filter Filter) ([]Cert, error) {
+	certs, err := y.readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []Cert
+	for _, cert := range certs {
+		if filter.Match(cert) {
+			matched = append(matched, cert)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+
+	return matched, nil
+}
+
+// decodeCerts decodes every entry of the certs sequence node, in order.
+func decodeCerts(certs *yaml.Node) ([]Cert, error) {
+	var decoded []Cert
+	for _, item := range certs.Content {
+		var cert Cert
+		if err := item.Decode(&cert); err != nil {
+			return nil, fmt.Errorf("failed decoding certificate at line %d: %v", item.Line, err)
+		}
+		decoded = append(decoded, cert)
+	}
+
+	return decoded, nil
+}
+
+// indexOf returns the position of the named entry in the certs sequence
+// node, or -1 if it is not there.
+func indexOf(certs *yaml.Node, name string) (int, error) {
+	decoded, err := decodeCerts(certs)
+	if err != nil {
+		return -1, err
+	}
+	for i, cert := range decoded {
+		if cert.Name == name {
+			return i, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// modify applies fn to the current definitions and reloads what the store
+// serves, holding the configuration lock for the whole read-modify-write
+// cycle. fn changes the files with editFile.
+func (y *Yaml) modify(fn func(defs *Definitions) error) error {
+	unlock, err := lockFile(y.Path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed locking configuration file %v: %v", y.Path, err)
+	}
+	defer unlock()
+
+	defs := &Definitions{}
+	if _, err := os.Stat(y.Path); !os.IsNotExist(err) {
+		if defs, err = y.Load(); err != nil {
+			return err
+		}
+	}
+
+	if err := fn(defs); err != nil {
+		return err
+	}
+
+	defs, err = y.Load()
+	if err != nil {
+		return err
+	}
+	y.Swap(defs)
+
+	return nil
+}
+
+// editFile applies fn to the parsed file at path, which need not exist yet,
+// and writes the result back.
+func editFile(path string, fn func(doc *yaml.Node) error) error {
+	mode := os.FileMode(0644)
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed reading configuration file %v: %v", path, err)
+	}
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode()
+	}
+
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return fmt.Errorf("failed umarshaling yaml config (%v): %v", path, err)
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+
+	if err := fn(doc); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	// The encoder indents lists under their key, so lists written at the
+	// margin of their key are re-indented by the first edit.
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed marshaling yaml config (%v): %v", path, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed marshaling yaml config (%v): %v", path, err)
+	}
+
+	return writeFileAtomic(path, buf.Bytes(), mode)
+}
+
+// certsNode returns the sequence node holding the certs list, creating it if
+// the document does not have one yet.
+func certsNode(doc *yaml.Node) (*yaml.Node, error) {
+	if len(doc.Content) == 0 {
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"})
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("configuration root at line %d is not a mapping", root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "certs" {
+			continue
+		}
+		certs := root.Content[i+1]
+		if certs.Kind == yaml.ScalarNode && certs.Tag == "!!null" {
+			certs.Kind = yaml.SequenceNode
+			certs.Tag = "!!seq"
+			certs.Value = ""
+		}
+		if certs.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("certs at line %d is not a list", certs.Line)
+		}
+		return certs, nil
+	}
+
+	certs := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	root.Content = append(root.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "certs"},
+		certs,
+	)
+
+	return certs, nil
+}
+
+// yamlCertList edits the certs sequence node of a YAML document, keeping
+// comments and anchors.
+type yamlCertList struct {
+	doc   *yaml.Node
+	certs *yaml.Node
+}
+
+func (l *yamlCertList) index(name string) (int, error) {
+	return indexOf(l.certs, name)
+}
+
+func (l *yamlCertList) add(cert Cert) error {
+	node, err := encodeCert(l.doc, cert)
+	if err != nil {
+		return err
+	}
+	l.certs.Content = append(l.certs.Content, node)
+
+	return nil
+}
+
+// replace keeps the comments attached to the replaced entry.
+func (l *yamlCertList) replace(i int, cert Cert) error {
+	node, err := encodeCert(l.doc, cert)
+	if err != nil {
+		return err
+	}
+	old := l.certs.Content[i]
+	node.HeadComment = old.HeadComment
+	node.LineComment = old.LineComment
+	node.FootComment = old.FootComment
+	l.certs.Content[i] = node
+
+	return nil
+}
+
+func (l *yamlCertList) remove(names map[string]bool) error {
+	all, err := decodeCerts(l.certs)
+	if err != nil {
+		return err
+	}
+
+	var kept []*yaml.Node
+	for i, item := range l.certs.Content {
+		if !names[all[i].Name] {
+			kept = append(kept, item)
+		}
+	}
+	l.certs.Content = kept
+
+	return nil
+}
+
+// encodeCert encodes cert as a mapping node. When the subject matches one that
+// is already anchored in the document an alias to it is used instead.
+func encodeCert(doc *yaml.Node, cert Cert) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(cert); err != nil {
+		return nil, fmt.Errorf("failed encoding certificate %v: %v", cert.Name, err)
+	}
+
+	anchor := findAnchor(doc, func(n *yaml.Node) bool {
+		var s Subject
+		return n.Decode(&s) == nil && reflect.DeepEqual(s, cert.Subject)
+	})
+	if anchor == nil {
+		return node, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "subject" {
+			node.Content[i+1] = &yaml.Node{Kind: yaml.AliasNode, Value: anchor.Anchor, Alias: anchor}
+		}
+	}
+
+	return node, nil
+}
+
+// findAnchor returns the first anchored mapping node for which match is true.
+func findAnchor(n *yaml.Node, match func(n *yaml.Node) bool) *yaml.Node {
+	if n.Anchor != "" && n.Kind == yaml.MappingNode && match(n) {
+		return n
+	}
+	for _, c := range n.Content {
+		if found := findAnchor(c, match); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes b to a temporary file next to path and renames it
+// over path, so readers never observe a partially written file.
+func writeFileAtomic(path string, b []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return fmt.Errorf("failed creating temporary file for %v: %v", path, err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("failed writing temporary file for %v: %v", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed syncing temporary file for %v: %v", path, err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return fmt.Errorf("failed setting mode of temporary file for %v: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed closing temporary file for %v: %v", path, err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed replacing %v: %v", path, err)
+	}
+
+	return nil
 }
 
 func (y *Yaml) Get(name string) (*Cert, error) {
@@ -61,32 +809,54 @@ func (y *Yaml) Tree() ([]TreeNode, error) {
 		return nil, err
 	}
 
+	return buildTree(certs), nil
+}
+
+// buildTree arranges certs into trees below their roots, every node holding
+// its children.
+func buildTree(certs []Cert) []TreeNode {
 	flat := certsBySigner{}
 	for _, cert := range certs {
-		flat[cert.Signer] = append(flat[cert.Signer], cert)
-	}
-
-	for key, value := range flat {
-		t := key + " - "
-		for _, v := range value {
-			t += v.Name + ", "
+		if !selfSigned(cert) {
+			flat[cert.Signer] = append(flat[cert.Signer], cert)
 		}
 	}
 
 	var roots []TreeNode
 	for _, cert := range certs {
 		// Roots are all CA certificates that are self signed, or externally signed
-		if cert.IsCA && (cert.Signer == "" || cert.Signer == cert.Name || flat[cert.Signer] == nil) {
-			roots = append(roots, &YamlCertNode{self: cert, certsBySigner: &flat})
+		if cert.IsCA && (selfSigned(cert) || !defined(certs, cert.Signer)) {
+			roots = append(roots, flat.node(cert))
 		}
 	}
 
-	return roots, nil
+	return roots
+}
+
+// node returns the node of cert with the certificates it signs below it.
+// Every certificate has one signer, so nothing below a root signs it.
+func (flat certsBySigner) node(cert Cert) *YamlCertNode {
+	n := &YamlCertNode{self: cert, children: []TreeNode{}}
+	for _, child := range flat[cert.Name] {
+		n.children = append(n.children, flat.node(child))
+	}
+
+	return n
+}
+
+func defined(certs []Cert, name string) bool {
+	for _, cert := range certs {
+		if cert.Name == name {
+			return true
+		}
+	}
+
+	return false
 }
 
 type YamlCertNode struct {
-	self          Cert
-	certsBySigner *certsBySigner
+	self     Cert
+	children []TreeNode
 }
 
 func (n *YamlCertNode) Self() Cert {
@@ -94,15 +864,5 @@ func (n *YamlCertNode) Self() Cert {
 }
 
 func (n *YamlCertNode) Children() []TreeNode {
-	children := (*n.certsBySigner)[n.self.Name]
-	if children == nil {
-		return []TreeNode{}
-	}
-
-	var nodes []TreeNode
-	for _, cert := range children {
-		nodes = append(nodes, &YamlCertNode{self: cert, certsBySigner: n.certsBySigner})
-	}
-
-	return nodes
+	return n.children
 }
@@ -0,0 +1,357 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"easypki-ui/config"
+	"easypki-ui/config/storetest"
+)
+
+func TestYamlStore(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// path returns where the store of a new, empty directory is kept.
+		path func(dir string) string
+	}{
+		{"yaml file", func(dir string) string { return filepath.Join(dir, "pki.yml") }},
+		{"json file", func(dir string) string { return filepath.Join(dir, "pki.json") }},
+		{"toml file", func(dir string) string { return filepath.Join(dir, "pki.toml") }},
+		{"directory", func(dir string) string { return dir }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := storetest.TestStore(func() (config.Store, error) {
+				return &config.Yaml{Path: tc.path(t.TempDir())}, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestYamlStoreFormat(t *testing.T) {
+	for _, format := range []string{config.FormatYAML, config.FormatJSON, config.FormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			err := storetest.TestStore(func() (config.Store, error) {
+				return &config.Yaml{Path: filepath.Join(t.TempDir(), "pki.conf"), Format: format}, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestYamlMissingFile(t *testing.T) {
+	y := &config.Yaml{Path: filepath.Join(t.TempDir(), "pki.yml")}
+	if cert, err := y.Get("Root CA"); err != nil || cert != nil {
+		t.Errorf("Get on missing file = %v, %v; want nil, nil", cert, err)
+	}
+	if certs, err := y.List(config.Filter{}); err != nil || len(certs) != 0 {
+		t.Errorf("List on missing file = %v, %v; want no certificates", certs, err)
+	}
+	if _, err := os.Stat(y.Path); !os.IsNotExist(err) {
+		t.Errorf("reading created %v", y.Path)
+	}
+}
+
+func TestYamlAddKeepsDocument(t *testing.T) {
+	b, err := ioutil.ReadFile("../pki.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Share the subject of the root CA with the intermediate through an
+	// anchor, and comment the root CA.
+	src := strings.Replace(string(b), "- name: \"CA\"", "# The root of every chain.\n- name: \"CA\"", 1)
+	src = strings.Replace(src, "  subject:\n", "  subject: &subject\n", 1)
+	src = strings.Replace(src, "  inheritSubject: true\n", "  subject: *subject\n", 1)
+	path := filepath.Join(t.TempDir(), "pki.yml")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	y := &config.Yaml{Path: path}
+	if err := y.Add(config.Cert{Name: "web", Signer: "CA", DNSNames: []string{"web"}}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# yaml-language-server: $schema=pki.schema.json",
+		"# The root of every chain.",
+		"subject: &subject",
+		"subject: *subject",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Add() dropped %q:\n%s", want, out)
+		}
+	}
+
+	ca, err := y.Get("Admins Intermediate CA")
+	if err != nil || ca == nil {
+		t.Fatalf("Get() = %v, %v", ca, err)
+	}
+	if got := ca.Subject.Organization; len(got) != 1 || got[0] != "Acme Inc." {
+		t.Errorf("aliased organization = %v, want [Acme Inc.]", got)
+	}
+	if web, err := y.Get("web"); err != nil || web == nil {
+		t.Errorf("Get(web) = %v, %v", web, err)
+	}
+}
+
+// writeFiles writes the files, keyed by their path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestYamlInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pki.yml": `include:
+  - "teams/*.yml"
+  - "shared"
+certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    expire: 8760h
+`,
+		// Including the file that included it reads it once.
+		"teams/web.yml": `include: ../pki.yml
+certs:
+  - name: web
+    commonName: web
+    signer: Root CA
+    expire: 720h
+`,
+		"teams/skipped.yaml": `certs:
+  - name: skipped
+    commonName: skipped
+    signer: Root CA
+    expire: 720h
+`,
+		"shared/10-mail.yml": `certs:
+  - name: mail
+    commonName: mail
+    signer: Root CA
+    expire: 720h
+`,
+		"shared/20-api.json": `{"certs": [{"name": "api", "commonName": "api", "signer": "Root CA", "expire": "720h"}]}`,
+		"shared/.hidden.yml": `certs: [{name: hidden}]`,
+		"shared/notes.txt":   `not a configuration file`,
+	})
+
+	y := &config.Yaml{Path: filepath.Join(dir, "pki.yml")}
+	defs, err := y.Load()
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	files := map[string]string{}
+	for _, cert := range defs.Certs {
+		rel, _ := filepath.Rel(dir, cert.Source.File)
+		files[cert.Name] = rel
+	}
+	want := map[string]string{
+		"Root CA": "pki.yml",
+		"web":     filepath.Join("teams", "web.yml"),
+		"mail":    filepath.Join("shared", "10-mail.yml"),
+		"api":     filepath.Join("shared", "20-api.json"),
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Load() read %v, want %v", files, want)
+	}
+	if err := config.Validate(y); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	// Certificates go to the file of their signer.
+	if err := y.Add(config.Cert{Name: "db", CommonName: "db", Signer: "Root CA"}); err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	if cert, err := y.Get("db"); err != nil || cert == nil || cert.Source.File != y.Path {
+		t.Errorf("Get(db) = %+v, %v; want it defined in %v", cert, err, y.Path)
+	}
+
+	t.Run("directory", func(t *testing.T) {
+		y := &config.Yaml{Path: filepath.Join(dir, "shared")}
+		certs, err := y.List(config.Filter{})
+		if err != nil {
+			t.Fatalf("List() failed: %v", err)
+		}
+		var names []string
+		for _, cert := range certs {
+			names = append(names, cert.Name)
+		}
+		if !reflect.DeepEqual(names, []string{"api", "mail"}) {
+			t.Errorf("List() = %v, want [api mail]", names)
+		}
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		writeFiles(t, dir, map[string]string{"teams/web2.yml": `certs:
+  - name: web
+    commonName: web2
+    signer: Root CA
+    expire: 720h
+`})
+		defer os.Remove(filepath.Join(dir, "teams", "web2.yml"))
+		y := &config.Yaml{Path: filepath.Join(dir, "pki.yml")}
+		err := config.Validate(y)
+		first := filepath.Join(dir, "teams", "web.yml") + ":3"
+		if err == nil || !strings.Contains(err.Error(), "web2.yml:2: web: duplicate name, first defined at "+first) {
+			t.Errorf("Validate() = %v, want web reported as a duplicate of %v", err, first)
+		}
+	})
+
+	t.Run("missing include", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "pki.yml")
+		writeFiles(t, filepath.Dir(path), map[string]string{"pki.yml": "include: [missing.yml, \"none/*.yml\"]\n"})
+		_, err := (&config.Yaml{Path: path}).Load()
+		if err == nil || !strings.Contains(err.Error(), "missing.yml does not exist") || strings.Contains(err.Error(), "none") {
+			t.Errorf("Load() = %v, want only the missing file reported", err)
+		}
+	})
+}
+
+func TestJSONTOMLFormats(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"pki.yml": `profiles:
+  web:
+    keyUsage: [digitalSignature]
+    extKeyUsage: [serverAuth]
+    expire: 2160h
+certs:
+  - name: Root CA
+    commonName: Root CA
+    isCA: true
+    maxPathLen: 1
+    expire: 8760h
+    subject:
+      organization: [Acme Inc.]
+  - name: web
+    commonName: web.example.com
+    signer: Root CA
+    profile: web
+    dnsNames: [web.example.com]
+    ipAddresses: [192.0.2.1]
+`,
+		"pki.json": `{
+  "$schema": "pki.schema.json",
+  "profiles": {
+    "web": {
+      "keyUsage": ["digitalSignature"],
+      "extKeyUsage": ["serverAuth"],
+      "expire": "2160h"
+    }
+  },
+  "certs": [
+    {
+      "name": "Root CA",
+      "commonName": "Root CA",
+      "isCA": true,
+      "maxPathLen": 1,
+      "expire": "8760h",
+      "subject": {"organization": ["Acme Inc."]}
+    },
+    {
+      "name": "web",
+      "commonName": "web.example.com",
+      "signer": "Root CA",
+      "profile": "web",
+      "dnsNames": ["web.example.com"],
+      "ipAddresses": ["192.0.2.1"]
+    }
+  ]
+}
+`,
+		"pki.toml": `[profiles.web]
+keyUsage = ["digitalSignature"]
+extKeyUsage = ["serverAuth"]
+expire = "2160h"
+
+[[certs]]
+name = "Root CA"
+commonName = "Root CA"
+isCA = true
+maxPathLen = 1
+expire = "8760h"
+subject = {organization = ["Acme Inc."]}
+
+[[certs]]
+name = "web"
+commonName = "web.example.com"
+signer = "Root CA"
+profile = "web"
+dnsNames = ["web.example.com"]
+ipAddresses = ["192.0.2.1"]
+`,
+	})
+
+	load := func(name string) *config.Definitions {
+		t.Helper()
+		defs, err := (&config.Yaml{Path: filepath.Join(dir, name)}).Load()
+		if err != nil {
+			t.Fatalf("Load(%v) failed: %v", name, err)
+		}
+		for i := range defs.Certs {
+			defs.Certs[i].Source = config.Source{}
+		}
+		defs.Sources = nil
+		return defs
+	}
+	want := load("pki.yml")
+	for _, name := range []string{"pki.json", "pki.toml"} {
+		if got := load(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("Load(%v) = %+v, want %+v as read from YAML", name, got, want)
+		}
+	}
+
+	// Broken entries are reported with the line they start at.
+	for _, broken := range []struct{ name, data, line string }{
+		{"broken.json", `{
+  "certs": [
+    {"name": "Root CA", "commonName": "Root CA", "isCA": true, "expire": "8760h"},
+    {
+      "name": "web",
+      "expire": "soon"
+    }
+  ]
+}
+`, "4"},
+		{"broken.toml", `[[certs]]
+name = "Root CA"
+commonName = "Root CA"
+isCA = true
+expire = "8760h"
+
+[[certs]]
+name = "web"
+expire = "soon"
+`, "7"},
+	} {
+		path := filepath.Join(dir, broken.name)
+		writeFiles(t, dir, map[string]string{broken.name: broken.data})
+		_, err := (&config.Yaml{Path: path}).Load()
+		want := path + ":" + broken.line + ": web: "
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load(%v) = %v, want an error at %v", broken.name, err, want)
+		}
+	}
+}
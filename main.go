@@ -1,6 +1,9 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
 	"net/http"
 	"log"
 	"os"
@@ -25,10 +28,24 @@ func main() {
 	sp := settings.PkiSettings{}
 	sp.Create()
 
+	// The schema does not depend on any state, print it before requiring any.
+	if flag.Arg(0) == "schema" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(config.Schema()); err != nil {
+			log.Fatalf("Failed encoding schema: %v", err)
+		}
+		return
+	}
+
 	if sp.DbPath == "" {
 		log.Fatal("Arg db_path must be set.")
 	}
-	if sp.BundleName == "" && sp.ConfigPath == "" {
+	storeKind, storePath, err := sp.ConfigStoreLocation()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sp.BundleName == "" && sp.ConfigPath == "" && storeKind == "yaml" {
 		log.Fatal("One of bundle_name or config_path must be set.")
 	}
 
@@ -38,19 +55,166 @@ func main() {
 	}
 	defer db.Close()
 
+	var cs config.Store
+	var seeder interface {
+		Seed(from config.Store) (bool, error)
+	}
+	switch storeKind {
+	case "yaml":
+		cs = &config.Yaml{Path: sp.ConfigPath, Format: sp.ConfigFormat}
+	case "bolt":
+		b := &config.Bolt{DB: db}
+		cs, seeder = b, b
+	case "sqlite":
+		s, err := config.OpenSQLite(storePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		cs, seeder = s, s
+	default:
+		log.Fatalf("Unknown config_store %v, expected yaml, bolt or sqlite:///path.", sp.ConfigStore)
+	}
+	if seeder != nil && sp.ConfigPath != "" {
+		seeded, err := seeder.Seed(&config.Yaml{Path: sp.ConfigPath, Format: sp.ConfigFormat})
+		if err != nil {
+			log.Fatalf("Failed seeding configuration from %v: %v", sp.ConfigPath, err)
+		}
+		if seeded {
+			log.Printf("Seeded configuration from %v", sp.ConfigPath)
+		}
+	}
+
 	cfg := config.Config{
-		Store:   &config.Yaml{Path: sp.ConfigPath},
-		EasyPKI: &easypki.EasyPKI{Store: &store.Bolt{DB: db}},
+		Store:   cs,
+		EasyPKI: &easypki.EasyPKI{Store: &config.BoltPKI{Bolt: store.Bolt{DB: db}}},
+	}
+
+	if err := config.Validate(cs); err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "", "serve":
+	case "validate":
+		log.Println("Configuration is valid.")
+		return
+	case "plan":
+		plan, err := cfg.Plan()
+		if err != nil {
+			log.Fatalf("Failed planning: %v", err)
+		}
+		plan.Write(os.Stdout)
+		return
+	case "apply":
+		plan, err := cfg.Plan()
+		if err != nil {
+			log.Fatalf("Failed planning: %v", err)
+		}
+		plan.Write(os.Stdout)
+		failed := false
+		for _, r := range cfg.Apply(plan) {
+			if r.Action != config.Unchanged {
+				log.Println(r)
+			}
+			failed = failed || r.Action == config.Failed
+		}
+		if failed {
+			log.Fatal("Apply failed for some certificates.")
+		}
+		return
+	case "import":
+		// Imported CAs come with their key, externally signed ones with the
+		// certificates above them.
+		if flag.NArg() < 3 {
+			log.Fatal("Usage: import <name> <PEM file>...")
+		}
+		var data []byte
+		for _, path := range flag.Args()[2:] {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatalf("Failed reading %v: %v", path, err)
+			}
+			data = append(append(data, b...), '\n')
+		}
+		certs, key, err := config.ParsePEM(data)
+		if err != nil {
+			log.Fatalf("Failed reading certificate: %v", err)
+		}
+		if key != nil {
+			err = cfg.ImportCA(flag.Arg(1), certs[0], key)
+		} else {
+			err = cfg.Import(flag.Arg(1), certs[0], certs[1:])
+		}
+		if err != nil {
+			log.Fatalf("Failed importing %v: %v", flag.Arg(1), err)
+		}
+		log.Printf("Imported %v, apply to issue the certificates below it.", flag.Arg(1))
+		return
+	default:
+		log.Fatalf("Unknown command %v, expected serve, plan, apply, import, validate or schema.", flag.Arg(0))
+	}
+
+	// File based configurations are reloaded when they change or on SIGHUP.
+	var reloader *config.Reloader
+	if rs, ok := cs.(config.Reloadable); ok {
+		reloader = &config.Reloader{Config: &cfg, Source: rs, Interval: sp.ReloadInterval}
+		if err := reloader.Reload(); err != nil {
+			log.Fatalf("Failed loading configuration: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go reloader.Watch(ctx, sp.ConfigPath)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				reloader.Reload()
+			}
+		}()
+	} else {
+		results, err := cfg.Init()
+		if err != nil {
+			log.Fatalf("Failed reading configuration: %v", err)
+		}
+		for _, r := range results {
+			log.Println(r)
+		}
 	}
-	cfg.Init()
 
 	ws := settings.WebServerSettings{}
 	ws.Create()
 
-	r := mux.NewRouter()
+	// OCSP GET requests are base64 in the path, which cleaning would mangle.
+	r := mux.NewRouter().SkipClean(true)
+
 
+	// Routes changing the PKI require a user authenticated by one of the
+	// identity providers, unless anonymous changes are explicitly allowed.
+	authProviders, err := ws.Providers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case len(authProviders) > 0 && ws.AnonymousWrites:
+		log.Fatal("ANONYMOUS_WRITES cannot be set together with OIDC_PROVIDERS.")
+	case len(authProviders) > 0 && ws.ClientID == "":
+		log.Fatal("OIDC_PROVIDERS requires OIDC_CLIENT_ID, the client ID tokens must be issued for.")
+	case len(authProviders) > 0:
+		providers := api.Providers{ClientID: ws.ClientID}
+		for _, p := range authProviders {
+			providers.Add(p)
+		}
+		r.Use(api.AuthMiddleware(providers))
+	case ws.AnonymousWrites:
+		log.Println("Warning: ANONYMOUS_WRITES is set, anyone can change the PKI.")
+	default:
+		log.Println("OIDC_PROVIDERS is not set, routes changing the PKI are refused. Set ANONYMOUS_WRITES=true to allow them without authentication.")
+	}
 
-	a := api.API{}
+	a := api.API{Reloader: reloader, Anonymous: ws.AnonymousWrites}
 	a.Setup(&cfg, r.PathPrefix("/api").Subrouter())
 
 	r.Use(mux.CORSMethodMiddleware(r))
@@ -1,6 +1,12 @@
 package settings
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
 
 type PkiSettings struct {
 	CaName     string
@@ -8,15 +14,28 @@ type PkiSettings struct {
 	FullChain  bool
 	DbPath     string
 	ConfigPath string
+	// ConfigFormat is the format of the file at ConfigPath: yaml, json or
+	// toml. Empty picks it by extension.
+	ConfigFormat string
+	// ConfigStore selects where certificate definitions are kept: "yaml"
+	// reads them from ConfigPath, "bolt" keeps them in the database at DbPath
+	// and "sqlite:///path" in a SQLite database at path.
+	ConfigStore string
+	// ReloadInterval is how often config_path is polled for changes when it
+	// cannot be watched.
+	ReloadInterval time.Duration
 }
 
 func (s *PkiSettings) Create() {
 	var (
-		caName     = flag.String("ca_name", "", "Name of the CA which signed the bundle.")
-		bundleName = flag.String("bundle_name", "", "Name of the bundle to retrieve.")
-		fullChain  = flag.Bool("full_chain", true, "Include chain of trust in certificate output.")
-		dbPath     = flag.String("db_path", "", "Bolt database path.")
-		configPath = flag.String("config_path", "", "Configuration path to generate PKI.")
+		caName         = flag.String("ca_name", "", "Name of the CA which signed the bundle.")
+		bundleName     = flag.String("bundle_name", "", "Name of the bundle to retrieve.")
+		fullChain      = flag.Bool("full_chain", true, "Include chain of trust in certificate output.")
+		dbPath         = flag.String("db_path", "", "Bolt database path.")
+		configPath     = flag.String("config_path", "", "Configuration file, or directory of configuration files, to generate PKI.")
+		configFormat   = flag.String("config_format", "", "Format of config_path: yaml, json or toml. By default it is picked by extension.")
+		reloadInterval = flag.Duration("reload_interval", 5*time.Second, "How often config_path is polled for changes when file notifications are unavailable.")
+		configStore    = flag.String("config_store", "yaml", "Where certificate definitions are stored: yaml, bolt or sqlite:///path. Database stores are seeded once from config_path when set.")
 	)
 
 	flag.Parse()
@@ -26,4 +45,29 @@ func (s *PkiSettings) Create() {
 	s.FullChain = *fullChain
 	s.DbPath = *dbPath
 	s.ConfigPath = *configPath
+	s.ConfigFormat = *configFormat
+	s.ConfigStore = *configStore
+	s.ReloadInterval = *reloadInterval
+}
+
+// ConfigStoreLocation splits ConfigStore into the kind of store and, for
+// stores given as a URL, the path it refers to.
+func (s *PkiSettings) ConfigStoreLocation() (string, string, error) {
+	if !strings.Contains(s.ConfigStore, ":") {
+		return s.ConfigStore, "", nil
+	}
+
+	u, err := url.Parse(s.ConfigStore)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid config_store %v: %v", s.ConfigStore, err)
+	}
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+	if path == "" {
+		return "", "", fmt.Errorf("config_store %v has no path", s.ConfigStore)
+	}
+
+	return u.Scheme, path, nil
 }
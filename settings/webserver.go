@@ -1,7 +1,11 @@
 package settings
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -9,9 +13,34 @@ type WebServerSettings struct {
 	Address string
 	// "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m"
 	GracefulTimeout time.Duration
+	// AuthProviders lists the OpenID Connect discovery URLs of the identity
+	// providers users authenticate with, separated by spaces.
+	AuthProviders string
+	// ClientID is the OpenID Connect client ID tokens must be issued for.
+	ClientID string
+	// AnonymousWrites lets anyone change the PKI when there are no
+	// AuthProviders.
+	AnonymousWrites bool
 }
 
 func (s *WebServerSettings) Create() {
 	s.Address = os.Getenv("HTTP_LISTEN")
 	s.GracefulTimeout = time.Second * 15
+	s.AuthProviders = os.Getenv("OIDC_PROVIDERS")
+	s.ClientID = os.Getenv("OIDC_CLIENT_ID")
+	s.AnonymousWrites, _ = strconv.ParseBool(os.Getenv("ANONYMOUS_WRITES"))
+}
+
+// Providers parses AuthProviders.
+func (s *WebServerSettings) Providers() ([]url.URL, error) {
+	var providers []url.URL
+	for _, field := range strings.Fields(s.AuthProviders) {
+		u, err := url.Parse(field)
+		if err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("invalid OIDC provider discovery URL %q", field)
+		}
+		providers = append(providers, *u)
+	}
+
+	return providers, nil
 }